@@ -0,0 +1,50 @@
+package stomp
+
+import (
+	"net"
+	"strings"
+)
+
+const (
+	// DefaultPort is the port used for addresses without one.
+	DefaultPort = "61613"
+
+	// DefaultTLSPort is the port used for TLS addresses without one.
+	DefaultTLSPort = "61614"
+)
+
+// splitAddr splits addr into a host and port.
+// Bracketed and bare IPv6 literals are accepted. A missing port is
+// reported as an empty string.
+func splitAddr(addr string) (host, port string, err error) {
+	switch {
+	case strings.HasPrefix(addr, "["):
+		if !strings.HasSuffix(addr, "]") {
+			return net.SplitHostPort(addr)
+		}
+		return addr[1 : len(addr)-1], "", nil
+	case strings.Count(addr, ":") > 1:
+		// A bare IPv6 literal cannot carry a port.
+		return addr, "", nil
+	case strings.Contains(addr, ":"):
+		return net.SplitHostPort(addr)
+	}
+	return addr, "", nil
+}
+
+// normalizeAddr returns addr in host:port form, along with its host.
+// A missing port defaults to DefaultPort, or DefaultTLSPort if
+// useTLS is true.
+func normalizeAddr(addr string, useTLS bool) (string, string, error) {
+	host, port, err := splitAddr(strings.TrimSpace(addr))
+	if err != nil {
+		return "", "", err
+	}
+	if port == "" {
+		port = DefaultPort
+		if useTLS {
+			port = DefaultTLSPort
+		}
+	}
+	return net.JoinHostPort(host, port), host, nil
+}
@@ -0,0 +1,149 @@
+package stomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// AdvisoryKind identifies a family of ActiveMQ advisory topics.
+type AdvisoryKind string
+
+const (
+	// ConsumerAdvisory reports consumers starting and stopping on a destination.
+	ConsumerAdvisory AdvisoryKind = "Consumer"
+
+	// QueueFullAdvisory reports a destination reaching its memory limit.
+	QueueFullAdvisory AdvisoryKind = "FULL"
+
+	// SlowConsumerAdvisory reports a consumer on a destination falling behind.
+	SlowConsumerAdvisory AdvisoryKind = "SlowConsumer"
+)
+
+const advisoryPrefix = "/topic/ActiveMQ.Advisory."
+
+// AdvisoryTopic returns the advisory topic of kind for the destination dest.
+// The destination must be of the form /queue/name or /topic/name.
+func AdvisoryTopic(kind AdvisoryKind, dest string) (string, error) {
+	switch {
+	case strings.HasPrefix(dest, "/queue/"):
+		return advisoryPrefix + string(kind) + ".Queue." + strings.TrimPrefix(dest, "/queue/"), nil
+	case strings.HasPrefix(dest, "/topic/"):
+		return advisoryPrefix + string(kind) + ".Topic." + strings.TrimPrefix(dest, "/topic/"), nil
+	}
+	return "", fmt.Errorf("stomp: unsupported advisory destination %s", dest)
+}
+
+// SubscribeAdvisory subscribes to the advisory topic of kind for the destination dest.
+// Advisory messages are requested as JSON and should be read with ParseAdvisory.
+// SubscribeAdvisory returns the subscription ID.
+// A true receipt value will use a receipt for the frame.
+func (c *Client) SubscribeAdvisory(kind AdvisoryKind, dest string, receipt bool) (id string, err error) {
+	topic, err := AdvisoryTopic(kind, dest)
+	if err != nil {
+		return "", err
+	}
+	return c.SubscribeWithHeaders(topic, AutoMode, &map[string]string{"transformation": "jms-advisory-json"}, receipt)
+}
+
+// Advisory is a parsed ActiveMQ advisory message.
+type Advisory struct {
+	// Kind is the advisory kind.
+	Kind AdvisoryKind
+
+	// Destination is the destination the advisory refers to,
+	// in the form /queue/name or /topic/name.
+	Destination string
+
+	// Started reports whether a consumer advisory announces a new consumer.
+	// It is false for consumers that have stopped.
+	Started bool
+
+	// ConsumerCount is the number of consumers on the destination,
+	// as reported by consumer advisories.
+	ConsumerCount int
+
+	// ConsumerID is the consumer reported by slow consumer advisories.
+	ConsumerID string
+
+	// BrokerName, BrokerID and BrokerURL identify the originating broker.
+	BrokerName string
+	BrokerID   string
+	BrokerURL  string
+
+	// DataType is the name of the attached data structure, e.g. ConsumerInfo.
+	DataType string
+
+	// Data is the JSON encoded data structure, if any.
+	Data json.RawMessage
+}
+
+// ParseAdvisory parses an advisory MESSAGE frame.
+// ParseAdvisory consumes the frame body.
+func ParseAdvisory(f *Frame) (*Advisory, error) {
+	if f.Command != "MESSAGE" {
+		return nil, fmt.Errorf("stomp: advisory frame has bad command %s", f.Command)
+	}
+
+	dest := f.Headers["destination"]
+	if !strings.HasPrefix(dest, advisoryPrefix) {
+		return nil, fmt.Errorf("stomp: %s is not an advisory topic", dest)
+	}
+
+	// The topic has the form <prefix><Kind>.<Queue|Topic>.<name>.
+	m := strings.SplitN(strings.TrimPrefix(dest, advisoryPrefix), ".", 3)
+	if len(m) != 3 {
+		return nil, fmt.Errorf("stomp: unable to parse advisory topic %s", dest)
+	}
+
+	a := &Advisory{
+		Kind:       AdvisoryKind(m[0]),
+		ConsumerID: f.Headers["consumerId"],
+		BrokerName: f.Headers["originBrokerName"],
+		BrokerID:   f.Headers["originBrokerId"],
+		BrokerURL:  f.Headers["originBrokerURL"],
+	}
+
+	switch m[1] {
+	case "Queue":
+		a.Destination = "/queue/" + m[2]
+	case "Topic":
+		a.Destination = "/topic/" + m[2]
+	default:
+		return nil, fmt.Errorf("stomp: unable to parse advisory topic %s", dest)
+	}
+
+	if v, ok := f.Headers["consumerCount"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("stomp: invalid advisory consumerCount %q", v)
+		}
+		a.ConsumerCount = n
+	}
+
+	if f.Body != nil {
+		buf, err := ioutil.ReadAll(f.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		// The body is a single object keyed by the data structure name.
+		if len(strings.TrimSpace(string(buf))) > 0 {
+			var data map[string]json.RawMessage
+			err = json.Unmarshal(buf, &data)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range data {
+				a.DataType = k
+				a.Data = v
+			}
+		}
+	}
+
+	a.Started = a.Kind == ConsumerAdvisory && a.DataType == "ConsumerInfo"
+
+	return a, nil
+}
@@ -0,0 +1,58 @@
+//go:build stompbench
+// +build stompbench
+
+package stomp
+
+import "testing"
+
+// benchBatch is the batch size used by the delivery benchmarks.
+const benchBatch = 64
+
+// BenchmarkChanDelivery measures handing messages from the read loop
+// to a consumer reading MsgCh with Receive.
+func BenchmarkChanDelivery(b *testing.B) {
+	c := &Client{
+		receipts: newReceipts(),
+		quit:     make(chan struct{}),
+		MsgCh:    make(chan *Frame),
+	}
+	c.msgs = c.MsgCh
+	benchDelivery(b, c, func() { close(c.MsgCh) })
+}
+
+// BenchmarkRingDelivery measures handing messages from the read loop
+// to a consumer reading a ring buffer with Receive.
+func BenchmarkRingDelivery(b *testing.B) {
+	c := &Client{
+		receipts: newReceipts(),
+		quit:     make(chan struct{}),
+		ring:     newRing(1024),
+	}
+	benchDelivery(b, c, c.ring.Close)
+}
+
+func benchDelivery(b *testing.B, c *Client, end func()) {
+	f := NewFrame("MESSAGE", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	go func() {
+		for i := 0; i < b.N; i++ {
+			c.deliver(f)
+		}
+		end()
+	}()
+
+	batch := make([]*Frame, benchBatch)
+	n := 0
+	for {
+		m, _ := c.Receive(batch)
+		if m == 0 {
+			break
+		}
+		n += m
+	}
+	if n != b.N {
+		b.Fatalf("received %d of %d messages", n, b.N)
+	}
+}
@@ -2,15 +2,28 @@ package stomp
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 )
 
+// ErrBrokerShutdown is returned when the broker shuts down the connection,
+// either with a shutdown ERROR frame or by closing the stream.
+// Callers managing failover should try another broker.
+var ErrBrokerShutdown = errors.New("stomp: broker shut down the connection")
+
+var errClosed = errors.New("stomp: channel closed")
+
 type receipts struct {
 	closed chan struct{}
+	err    error
 	orders map[string]chan struct{}
 	lock   *sync.Mutex
 }
@@ -23,6 +36,12 @@ func newReceipts() *receipts {
 	}
 }
 
+// Close fails all outstanding receipts with err.
+func (r *receipts) Close(err error) {
+	r.err = err
+	close(r.closed)
+}
+
 func (r *receipts) Mark(id string) chan struct{} {
 	r.lock.Lock()
 	defer r.lock.Unlock()
@@ -64,7 +83,7 @@ func doWithReceipt(r *receipts, f receiptFunc) (err error) {
 	select {
 	case <-ch:
 	case <-r.closed:
-		return fmt.Errorf("stomp: channel closed")
+		return r.err
 	}
 
 	return nil
@@ -76,9 +95,26 @@ func doWithReceipt(r *receipts, f receiptFunc) (err error) {
 type Client struct {
 	transport *Transport
 	receipts  *receipts
+	activity  *activity
+	subs      *subscriptions
+	msgs      chan<- *Frame
+	ring      *ring
+	mirrors   []Mirror
+	nack      bool
+
+	disconnecting int32
+
+	disconnectOnce sync.Once
+	disconnectErr  error
+	disconnected   chan struct{}
+
+	// quit is closed when Disconnect is called, releasing a read loop
+	// blocked on delivering to a consumer that has stopped receiving.
+	quit chan struct{}
 
 	// MsgCh provides a channel from which STOMP MESSAGE frames
-	// may be read.
+	// may be read. If Config.RingBuffer is set, messages are
+	// received with Receive instead and MsgCh is only closed.
 	MsgCh chan *Frame
 
 	// ErrCh provides a channel from which STOMP ERROR frames
@@ -89,6 +125,9 @@ type Client struct {
 // Connect creates a new client object and completes a STOMP handshake.
 // A nil conf value will use a default configuration.
 // A nil tr value indicates no TLS and will default to net.Dial.
+// The addr may omit the port, in which case DefaultPort or DefaultTLSPort
+// is used. IPv6 literals may be given with or without brackets.
+// If tr does not define a TLS ServerName, the host of addr is used.
 func Connect(addr string, conf *Config, tr *TransportConfig) (*Client, error) {
 	if conf == nil {
 		conf = DefaultConfig
@@ -98,14 +137,38 @@ func Connect(addr string, conf *Config, tr *TransportConfig) (*Client, error) {
 		tr = DefaultTransportConfig
 	}
 
+	var err error
+	tlsConf := tr.TLSConfig
+	if tr.GetTLSConfig != nil {
+		tlsConf, err = tr.GetTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	addr, host, err := normalizeAddr(addr, tlsConf != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dial := tr.Dial
+	if dial == nil {
+		dial = net.Dial
+	}
+
 	// Create an underlying tcp connection. Use TLS if requested.
-	conn, err := tr.Dial("tcp", addr)
+	conn, err := dial("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
+	conn = tr.Shaping.Wrap(conn)
 
-	if tr.TLSConfig != nil {
-		tlsConn := tls.Client(conn, tr.TLSConfig)
+	if tlsConf != nil {
+		if tlsConf.ServerName == "" && host != "" {
+			tlsConf = tlsConf.Clone()
+			tlsConf.ServerName = host
+		}
+		tlsConn := tls.Client(conn, tlsConf)
 
 		errc := make(chan error, 2)
 		var timer *time.Timer
@@ -131,6 +194,32 @@ func Connect(addr string, conf *Config, tr *TransportConfig) (*Client, error) {
 		conn = tlsConn
 	}
 
+	return ConnectStream(conn, conf)
+}
+
+// ConnectStream creates a new client object over the already open stream rwc
+// and completes a STOMP handshake.
+// A nil conf value will use a default configuration.
+// Heart-beat timeouts are only enforced if rwc has a SetReadDeadline method,
+// as net.Conn does. The stream is closed if the handshake fails.
+func ConnectStream(rwc io.ReadWriteCloser, conf *Config) (*Client, error) {
+	if conf == nil {
+		conf = DefaultConfig
+	}
+
+	var err error
+	login, passcode := conf.Login, conf.Passcode
+	if conf.Credentials != nil {
+		login, passcode, err = conf.Credentials()
+		if err != nil {
+			rwc.Close()
+			return nil, err
+		}
+	}
+
+	t := NewTransport(rwc)
+	t.dec.SetUTF8Policy(conf.HeaderUTF8)
+
 	req := NewFrame("CONNECT", nil)
 	req.Headers["accept-version"] = Version
 	if conf.Host != "" {
@@ -138,28 +227,29 @@ func Connect(addr string, conf *Config, tr *TransportConfig) (*Client, error) {
 	} else {
 		req.Headers["host"] = "/"
 	}
-	if conf.Login != "" {
-		req.Headers["login"] = conf.Login
+	if login != "" {
+		req.Headers["login"] = login
 	}
-	if conf.Passcode != "" {
-		req.Headers["passcode"] = conf.Passcode
+	if passcode != "" {
+		req.Headers["passcode"] = passcode
 	}
 	req.Headers["heart-beat"] = conf.Heartbeat.toString()
 
-	err = NewEncoder(conn).Encode(req)
+	err = t.encode(req)
 	if err != nil {
+		rwc.Close()
 		return nil, err
 	}
 
 	var resp Frame
-	err = NewDecoder(conn).Decode(&resp)
+	err = t.dec.Decode(&resp)
 	if err != nil {
-		conn.Close()
+		rwc.Close()
 		return nil, err
 	}
 
 	if resp.Command != "CONNECTED" {
-		defer conn.Close()
+		defer rwc.Close()
 
 		ct, ok := resp.Headers["content-type"]
 		if !ok {
@@ -193,13 +283,46 @@ func Connect(addr string, conf *Config, tr *TransportConfig) (*Client, error) {
 	}
 
 	c := &Client{
-		transport: NewTransport(conn),
+		transport: t,
 		receipts:  newReceipts(),
+		activity:  newActivity(),
+		subs:      newSubscriptions(),
+		mirrors:   conf.Mirrors,
+		nack:      conf.NackOnDisconnect,
 		MsgCh:     make(chan *Frame),
 		ErrCh:     make(chan *Frame, 1),
+
+		disconnected: make(chan struct{}),
+		quit:         make(chan struct{}),
+	}
+
+	var stages []stage
+	if len(c.mirrors) > 0 {
+		stages = append(stages, mirrorReceived(c.transport, c.mirrors))
+	}
+	if conf.DeliverAtHeader != "" {
+		stages = append(stages, deferDelivery(conf.DeliverAtHeader))
 	}
+	if conf.PriorityWindow > 0 {
+		stages = append(stages, prioritize(conf.PriorityWindow))
+	}
+
+	switch {
+	case conf.RingBuffer > 0 && len(stages) == 0:
+		// Deliver directly from the read loop to the ring.
+		c.ring = newRing(conf.RingBuffer)
+	case conf.RingBuffer > 0:
+		c.ring = newRing(conf.RingBuffer)
+		ch := make(chan *Frame)
+		go c.pump(ch)
+		c.msgs = pipeline(ch, stages...)
+	default:
+		c.msgs = pipeline(c.MsgCh, stages...)
+	}
+
 	go c.write(hb.Send)
 	go c.read(hb.Recv)
+	go c.idle(conf.IdleTimeout)
 
 	return c, nil
 }
@@ -217,10 +340,19 @@ func (c *Client) write(d time.Duration) {
 }
 
 func (c *Client) read(d time.Duration) {
+	var reason error
 loop:
 	for {
 		f, err := c.transport.Recv(d)
 		if err != nil {
+			switch {
+			case atomic.LoadInt32(&c.disconnecting) != 0:
+				reason = errClosed
+			case err == io.EOF:
+				reason = ErrBrokerShutdown
+			default:
+				reason = err
+			}
 			break loop
 		}
 
@@ -233,23 +365,113 @@ loop:
 			}
 			c.receipts.Clear(id)
 		case "MESSAGE":
-			c.MsgCh <- f
+			c.activity.touch()
+			c.subs.Delivered(f)
+			c.deliver(f)
 		case "ERROR":
+			if isShutdownError(f) {
+				reason = ErrBrokerShutdown
+			} else {
+				reason = fmt.Errorf("stomp: server error %s", f.Headers["message"])
+			}
 			c.ErrCh <- f
 			break loop
 		default:
 			panic(fmt.Sprintf("stomp: received unkown frame %s", f.Command))
 		}
 	}
-	close(c.receipts.closed)
-	close(c.MsgCh)
+	c.receipts.Close(reason)
+	if c.msgs != nil {
+		close(c.msgs)
+	} else {
+		c.ring.Close()
+		close(c.MsgCh)
+	}
+}
+
+// deliver hands f to the consumer. Once Disconnect has been called,
+// f is dropped rather than waiting for a consumer; it is left
+// unacknowledged for the broker to redeliver.
+func (c *Client) deliver(f *Frame) {
+	if c.msgs == nil {
+		c.ring.Push(f, c.quit)
+		return
+	}
+	select {
+	case c.msgs <- f:
+	case <-c.quit:
+	}
+}
+
+// shutdownPrefixes begin the messages of ERROR frames sent by brokers
+// that are closing connections because they are shutting down, such
+// as RabbitMQ's "CONNECTION_FORCED - broker forced connection closure".
+var shutdownPrefixes = []string{
+	"connection_forced",
+}
+
+// shutdownPhrases appear in the messages of ERROR frames sent by
+// brokers that are shutting down.
+var shutdownPhrases = []string{
+	"broker is shutting down",
+	"server is shutting down",
+}
+
+func isShutdownError(f *Frame) bool {
+	msg := strings.ToLower(strings.TrimSpace(f.Headers["message"]))
+	for _, p := range shutdownPrefixes {
+		if strings.HasPrefix(msg, p) {
+			return true
+		}
+	}
+	for _, p := range shutdownPhrases {
+		if strings.Contains(msg, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the reason the connection ended, or nil while it is open.
+// Err returns ErrBrokerShutdown if the broker shut the connection down.
+// Messages received before the connection ended are still delivered
+// to MsgCh before it is closed.
+func (c *Client) Err() error {
+	select {
+	case <-c.receipts.closed:
+		return c.receipts.err
+	default:
+		return nil
+	}
 }
 
 // Disconnect disconnect from the server and gracefully
 // shuts down the client and the underlying transport.
-func (c *Client) Disconnect() (err error) {
+// Subsequent calls return the result of the first call.
+// Messages that arrive once Disconnect has been called are dropped
+// if the consumer is not receiving them.
+func (c *Client) Disconnect() error {
+	c.disconnectOnce.Do(func() {
+		close(c.quit)
+		c.disconnectErr = c.disconnect()
+		close(c.disconnected)
+	})
+	return c.disconnectErr
+}
+
+func (c *Client) disconnect() (err error) {
 	defer c.transport.Close()
 
+	if c.nack {
+		for _, ack := range c.subs.Unacked() {
+			err = c.transport.Nack(ack, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	atomic.StoreInt32(&c.disconnecting, 1)
+
 	id, err := newUUID()
 	if err != nil {
 		return err
@@ -281,6 +503,18 @@ func (c *Client) Disconnect() (err error) {
 // A true receipt value will use a receipt for the message.
 // Send automatically generates a content-length for the provided body.
 func (c *Client) Send(dest string, hdrs *map[string]string, bodyType string, body io.Reader, receipt bool) error {
+	c.activity.touch()
+	if m, ok := findMirror(c.mirrors, dest, true); ok {
+		return sendMirrored(m, dest, hdrs, body, func(body io.Reader) error {
+			return c.send(dest, hdrs, bodyType, body, receipt)
+		}, func(hdrs *map[string]string, body io.Reader) error {
+			return c.transport.Send(m.Target, hdrs, bodyType, body, nil)
+		})
+	}
+	return c.send(dest, hdrs, bodyType, body, receipt)
+}
+
+func (c *Client) send(dest string, hdrs *map[string]string, bodyType string, body io.Reader, receipt bool) error {
 	if receipt {
 		return doWithReceipt(c.receipts, func(rid string) error {
 			return c.transport.Send(dest, hdrs, bodyType, body, &rid)
@@ -289,9 +523,76 @@ func (c *Client) Send(dest string, hdrs *map[string]string, bodyType string, bod
 	return c.transport.Send(dest, hdrs, bodyType, body, nil)
 }
 
+// SendMulti sends a single message to each of the destinations dests
+// using ActiveMQ composite destination syntax, such as queue://a,topic://b.
+// Each destination must be a queue or topic, as /queue/name or queue://name.
+// SendMulti otherwise behaves just as Send does.
+func (c *Client) SendMulti(dests []string, hdrs *map[string]string, bodyType string, body io.Reader, receipt bool) error {
+	dest, err := compositeDestination(dests)
+	if err != nil {
+		return err
+	}
+	return c.Send(dest, hdrs, bodyType, body, receipt)
+}
+
+// compositeDestination joins dests into an ActiveMQ composite destination
+// of the form queue://a,topic://b. Each destination must be a queue or
+// topic given as /queue/name, /topic/name, queue://name or topic://name;
+// surrounding whitespace is ignored.
+func compositeDestination(dests []string) (string, error) {
+	if len(dests) == 0 {
+		return "", fmt.Errorf("stomp: no destinations provided")
+	}
+	parts := make([]string, len(dests))
+	for i, d := range dests {
+		p, err := compositePart(strings.TrimSpace(d))
+		if err != nil {
+			return "", err
+		}
+		parts[i] = p
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// compositePrefixes maps accepted destination prefixes to their
+// composite destination form.
+var compositePrefixes = []struct {
+	prefix string
+	kind   string
+}{
+	{"/queue/", "queue://"},
+	{"/topic/", "topic://"},
+	{"queue://", "queue://"},
+	{"topic://", "topic://"},
+}
+
+func compositePart(d string) (string, error) {
+	if d == "" {
+		return "", fmt.Errorf("stomp: empty destination")
+	}
+	for _, p := range compositePrefixes {
+		if !strings.HasPrefix(d, p.prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(d, p.prefix)
+		switch {
+		case name == "":
+			return "", fmt.Errorf("stomp: destination %s has no name", d)
+		case strings.Contains(name, ","):
+			return "", fmt.Errorf("stomp: destination %s contains a comma", d)
+		case strings.IndexFunc(name, unicode.IsSpace) >= 0:
+			return "", fmt.Errorf("stomp: destination %q contains whitespace", d)
+		}
+		return p.kind + name, nil
+	}
+	return "", fmt.Errorf("stomp: destination %s is not a queue or topic", d)
+}
+
 // Ack sends an ACK frame.
 // A true receipt value will use a receipt for the frame.
 func (c *Client) Ack(id string, receipt bool) error {
+	c.activity.touch()
+	c.subs.Acked(id)
 	if receipt {
 		return doWithReceipt(c.receipts, func(rid string) error {
 			return c.transport.Ack(id, &rid)
@@ -303,6 +604,8 @@ func (c *Client) Ack(id string, receipt bool) error {
 // Nack sends an NACK frame.
 // A true receipt value will use a receipt for the frame.
 func (c *Client) Nack(id string, receipt bool) error {
+	c.activity.touch()
+	c.subs.Acked(id)
 	if receipt {
 		return doWithReceipt(c.receipts, func(rid string) error {
 			return c.transport.Nack(id, &rid)
@@ -329,25 +632,61 @@ const (
 // Subscribe returns the subscription ID.
 // A true receipt value will use a receipt for the frame.
 func (c *Client) Subscribe(dest string, mode AckMode, receipt bool) (id string, err error) {
+	return c.SubscribeWithHeaders(dest, mode, nil, receipt)
+}
+
+// SubscribeWithHeaders behaves just as Subscribe does, with the exception
+// of attaching the additional headers hdrs, such as a selector, to the frame.
+// The parameter hdrs may be nil, indicating that it will not be used.
+func (c *Client) SubscribeWithHeaders(dest string, mode AckMode, hdrs *map[string]string, receipt bool) (id string, err error) {
 	id, err = newUUID()
 	if err != nil {
 		return "", err
 	}
+	return id, c.SubscribeWithID(id, dest, mode, hdrs, receipt)
+}
+
+// SubscribeWithID behaves just as SubscribeWithHeaders does, with the
+// exception of using the subscription ID id, which must not be in use
+// on the connection. Choosing the ID lets callers prepare for messages
+// on the subscription before the SUBSCRIBE frame is sent.
+func (c *Client) SubscribeWithID(id string, dest string, mode AckMode, hdrs *map[string]string, receipt bool) error {
+	sub := Subscription{Destination: dest, Mode: mode}
+	if hdrs != nil {
+		sub.Headers = make(map[string]string, len(*hdrs))
+		for k, v := range *hdrs {
+			sub.Headers[k] = v
+		}
+	}
+	return c.subscribeID(id, sub, receipt)
+}
+
+func (c *Client) subscribeID(id string, sub Subscription, receipt bool) (err error) {
+	// Track the subscription before any message for it can arrive.
+	c.subs.Add(id, sub)
+	defer func() {
+		if err != nil {
+			c.subs.Remove(id)
+		}
+	}()
+
+	var hdrs *map[string]string
+	if sub.Headers != nil {
+		hdrs = &sub.Headers
+	}
 
 	if receipt {
-		err = doWithReceipt(c.receipts, func(rid string) error {
-			return c.transport.Subscribe(id, dest, mode, &rid)
+		return doWithReceipt(c.receipts, func(rid string) error {
+			return c.transport.SubscribeWithHeaders(id, sub.Destination, sub.Mode, hdrs, &rid)
 		})
-	} else {
-		err = c.transport.Subscribe(id, dest, mode, nil)
 	}
-
-	return id, err
+	return c.transport.SubscribeWithHeaders(id, sub.Destination, sub.Mode, hdrs, nil)
 }
 
 // Unsubscribe unsubscribes from the subscription with id.
 // A true receipt value will use a receipt for the frame.
 func (c *Client) Unsubscribe(id string, receipt bool) (err error) {
+	c.subs.Remove(id)
 	if receipt {
 		return doWithReceipt(c.receipts, func(rid string) error {
 			return c.transport.Unsubscribe(id, &rid)
@@ -381,6 +720,9 @@ func (c *Client) Begin(receipt bool) (tx *Tx, err error) {
 		tid:       tid,
 		done:      false,
 		receipts:  c.receipts,
+		activity:  c.activity,
+		subs:      c.subs,
+		mirrors:   c.mirrors,
 		transport: c.transport,
 	}
 	return tx, nil
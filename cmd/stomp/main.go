@@ -0,0 +1,44 @@
+// Command stomp is a command line tool for diagnosing STOMP brokers.
+//
+// Usage:
+//
+//	stomp proxy --listen :61614 --upstream broker:61613 [--rewrite rules.json] [--body]
+//	stomp run scenario.json
+//
+// See the documentation of each subcommand for its flags.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// commands maps subcommand names to their entry points.
+// Each receives the arguments following its name.
+var commands = map[string]func(args []string) error{
+	"proxy": proxy,
+	"run":   run,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: stomp <command> [flags]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  proxy   forward and log frames between clients and a broker")
+	fmt.Fprintln(os.Stderr, "  run     run a JSON scenario against a broker")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "stomp:", err)
+		os.Exit(1)
+	}
+}
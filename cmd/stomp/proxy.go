@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/djoyahoy/stomp"
+)
+
+// rule rewrites the headers of frames passing through the proxy.
+// Rules are loaded from a JSON array, for instance:
+//
+//	[
+//		{"from": "client", "command": "SEND", "set": {"persistent": "true"}},
+//		{"from": "broker", "delete": ["server"]}
+//	]
+type rule struct {
+	// From is "client" or "broker", matching frames sent by that side.
+	// An empty From matches frames in both directions.
+	From string `json:"from"`
+
+	// Command matches frames with this command. An empty Command
+	// matches every frame except heart-beats.
+	Command string `json:"command"`
+
+	Set    map[string]string `json:"set"`
+	Delete []string          `json:"delete"`
+}
+
+func (r *rule) matches(from string, f *stomp.Frame) bool {
+	if r.From != "" && r.From != from {
+		return false
+	}
+	return r.Command == "" || strings.EqualFold(r.Command, f.Command)
+}
+
+func (r *rule) apply(f *stomp.Frame) {
+	for _, k := range r.Delete {
+		delete(f.Headers, k)
+	}
+	for k, v := range r.Set {
+		f.Headers[k] = v
+	}
+}
+
+func loadRules(path string) ([]rule, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []rule
+	err = json.Unmarshal(buf, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %v", path, err)
+	}
+	for _, r := range rules {
+		switch r.From {
+		case "", "client", "broker":
+		default:
+			return nil, fmt.Errorf("invalid rule direction %q in %s", r.From, path)
+		}
+	}
+	return rules, nil
+}
+
+// proxy accepts client connections and forwards their frames to an
+// upstream broker, logging every frame in both directions and applying
+// any rewrite rules. Each client gets its own upstream connection.
+//
+//	--listen    address to accept clients on, e.g. :61614
+//	--upstream  broker address, e.g. broker:61613
+//	--rewrite   JSON file of header rewrite rules
+//	--body      also log frame bodies
+func proxy(args []string) error {
+	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+	listen := fs.String("listen", ":61614", "address to accept clients on")
+	upstream := fs.String("upstream", "", "broker address as host:port")
+	rewrite := fs.String("rewrite", "", "JSON file of header rewrite rules")
+	body := fs.Bool("body", false, "log frame bodies")
+	fs.Parse(args)
+
+	if *upstream == "" {
+		return fmt.Errorf("proxy: --upstream is required")
+	}
+	var rules []rule
+	if *rewrite != "" {
+		var err error
+		rules, err = loadRules(*rewrite)
+		if err != nil {
+			return err
+		}
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	p := &proxyServer{
+		upstream: *upstream,
+		rules:    rules,
+		body:     *body,
+		log:      log.New(os.Stderr, "", log.LstdFlags|log.Lmicroseconds),
+	}
+	p.log.Printf("proxying %s to %s", l.Addr(), *upstream)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.serve(conn)
+	}
+}
+
+type proxyServer struct {
+	upstream string
+	rules    []rule
+	body     bool
+	log      *log.Logger
+	conns    uint64
+}
+
+// serve forwards frames between client and a new upstream connection
+// until either side closes.
+func (p *proxyServer) serve(client net.Conn) {
+	id := atomic.AddUint64(&p.conns, 1)
+	p.log.Printf("[%d] client %s connected", id, client.RemoteAddr())
+
+	broker, err := net.Dial("tcp", p.upstream)
+	if err != nil {
+		p.log.Printf("[%d] unable to reach upstream: %v", id, err)
+		client.Close()
+		return
+	}
+
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			client.Close()
+			broker.Close()
+		})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer closeBoth()
+		p.forward(id, "client", client, broker)
+	}()
+	go func() {
+		defer wg.Done()
+		defer closeBoth()
+		p.forward(id, "broker", broker, client)
+	}()
+	wg.Wait()
+	p.log.Printf("[%d] closed", id)
+}
+
+// forward copies frames sent by from over src to dst.
+func (p *proxyServer) forward(id uint64, from string, src io.Reader, dst io.Writer) {
+	arrow := "client > broker"
+	if from == "broker" {
+		arrow = "broker > client"
+	}
+
+	dec := stomp.NewDecoder(src)
+	w := bufio.NewWriter(dst)
+	enc := stomp.NewEncoder(w)
+	for {
+		var f stomp.Frame
+		err := dec.Decode(&f)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				p.log.Printf("[%d] %s: %v", id, arrow, err)
+			}
+			return
+		}
+
+		var body []byte
+		if f.Body != nil {
+			body, err = ioutil.ReadAll(f.Body)
+			if err != nil {
+				p.log.Printf("[%d] %s: %v", id, arrow, err)
+				return
+			}
+		}
+		if f.Command != "HEARTBEAT" {
+			for i := range p.rules {
+				if p.rules[i].matches(from, &f) {
+					p.rules[i].apply(&f)
+				}
+			}
+		}
+		p.log.Printf("[%d] %s %s", id, arrow, p.describe(&f, body))
+
+		f.Body = ioutil.NopCloser(bytes.NewReader(body))
+		err = enc.Encode(&f)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			p.log.Printf("[%d] %s: %v", id, arrow, err)
+			return
+		}
+	}
+}
+
+// describe formats f for the log, with its headers in sorted order.
+func (p *proxyServer) describe(f *stomp.Frame, body []byte) string {
+	if f.Command == "HEARTBEAT" {
+		return "heart-beat"
+	}
+
+	keys := make([]string, 0, len(f.Headers))
+	for k := range f.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(f.Command)
+	for _, k := range keys {
+		v := f.Headers[k]
+		if k == "passcode" {
+			v = "***"
+		}
+		fmt.Fprintf(&b, " %s:%q", k, v)
+	}
+	fmt.Fprintf(&b, " (%d bytes)", len(body))
+	if p.body && len(body) > 0 {
+		fmt.Fprintf(&b, " %q", body)
+	}
+	return b.String()
+}
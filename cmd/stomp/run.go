@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/djoyahoy/stomp"
+)
+
+// defaultTimeout bounds each expect step and receipt wait that does
+// not set its own timeout.
+const defaultTimeout = 10 * time.Second
+
+// duration is a time.Duration encoded as a string such as 10s.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) or(def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
+// scenario is a sequence of steps run against a broker.
+// The connection, if set, is a configuration file read with
+// stomp.LoadConfig, resolved against the scenario's directory;
+// its subscriptions are made once connected. Without one, the
+// client connects to localhost with the default configuration.
+//
+//	{
+//		"connection": "client.json",
+//		"timeout": "10s",
+//		"steps": [
+//			{"subscribe": {"destination": "/queue/smoke", "ack": "client-individual", "receipt": true}},
+//			{"publish": {"destination": "/queue/smoke", "count": 10, "body": "ping", "receipt": true}},
+//			{"expect": {"count": 10, "destination": "/queue/smoke", "body": "ping", "timeout": "5s"}}
+//		]
+//	}
+type scenario struct {
+	Connection string   `json:"connection"`
+	Timeout    duration `json:"timeout"`
+	Steps      []step   `json:"steps"`
+}
+
+// step holds exactly one action.
+type step struct {
+	Subscribe *subscribeStep `json:"subscribe"`
+	Publish   *publishStep   `json:"publish"`
+	Expect    *expectStep    `json:"expect"`
+}
+
+type subscribeStep struct {
+	Destination string            `json:"destination"`
+	Mode        stomp.AckMode     `json:"ack"`
+	Headers     map[string]string `json:"headers"`
+	Receipt     bool              `json:"receipt"`
+}
+
+// publishStep sends Count messages, one by default.
+type publishStep struct {
+	Destination string            `json:"destination"`
+	Count       int               `json:"count"`
+	ContentType string            `json:"content_type"`
+	Body        string            `json:"body"`
+	Headers     map[string]string `json:"headers"`
+	Receipt     bool              `json:"receipt"`
+}
+
+// expectStep waits for Count messages, one by default, matching every
+// predicate that is set. Messages that do not match are skipped.
+// Received messages are acknowledged if their subscription requires it.
+type expectStep struct {
+	Count        int               `json:"count"`
+	Destination  string            `json:"destination"`
+	Headers      map[string]string `json:"headers"`
+	Body         *string           `json:"body"`
+	BodyContains string            `json:"body_contains"`
+	Timeout      duration          `json:"timeout"`
+}
+
+func (e *expectStep) matches(f *stomp.Frame, body string) bool {
+	if e.Destination != "" && f.Headers["destination"] != e.Destination {
+		return false
+	}
+	for k, v := range e.Headers {
+		if f.Headers[k] != v {
+			return false
+		}
+	}
+	if e.Body != nil && body != *e.Body {
+		return false
+	}
+	return strings.Contains(body, e.BodyContains)
+}
+
+func loadScenario(path string) (*scenario, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return nil, fmt.Errorf("YAML scenarios are not supported, use JSON")
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s scenario
+	err = json.Unmarshal(buf, &s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", path, err)
+	}
+
+	for i, st := range s.Steps {
+		n := 0
+		for _, set := range []bool{st.Subscribe != nil, st.Publish != nil, st.Expect != nil} {
+			if set {
+				n++
+			}
+		}
+		if n != 1 {
+			return nil, fmt.Errorf("step %d must have exactly one action", i+1)
+		}
+		if st.Subscribe != nil && st.Subscribe.Destination == "" {
+			return nil, fmt.Errorf("step %d: subscribe has no destination", i+1)
+		}
+		if st.Publish != nil && st.Publish.Destination == "" {
+			return nil, fmt.Errorf("step %d: publish has no destination", i+1)
+		}
+	}
+
+	if s.Connection != "" && !filepath.IsAbs(s.Connection) {
+		s.Connection = filepath.Join(filepath.Dir(path), s.Connection)
+	}
+	return &s, nil
+}
+
+// run executes a scenario file and fails on the first step that
+// does not succeed, so that the exit status reports the outcome.
+//
+//	stomp run scenario.json
+func run(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("run: expected a single scenario file")
+	}
+
+	s, err := loadScenario(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	fc := &stomp.FileConfig{Addrs: []string{"localhost"}}
+	if s.Connection != "" {
+		fc, err = stomp.LoadConfig(s.Connection)
+		if err != nil {
+			return err
+		}
+	}
+	c, err := connect(fc)
+	if err != nil {
+		return err
+	}
+	r := &runner{
+		c:       c,
+		timeout: s.Timeout.or(defaultTimeout),
+		msgs:    make(chan *stomp.Frame, 64),
+	}
+	defer r.wait(c.Disconnect)
+	go r.receive()
+
+	if len(fc.Subscriptions) > 0 {
+		err = r.wait(func() error {
+			_, err := fc.Subscribe(c, true)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("connection subscriptions: %v", err)
+		}
+	}
+
+	for i, st := range s.Steps {
+		desc, err := r.step(st)
+		if err != nil {
+			return fmt.Errorf("step %d: %s: %v", i+1, desc, err)
+		}
+		fmt.Printf("ok   %d %s\n", i+1, desc)
+	}
+	return nil
+}
+
+// connect connects to the first reachable address of fc.
+func connect(fc *stomp.FileConfig) (*stomp.Client, error) {
+	if len(fc.Addrs) == 0 {
+		return nil, fmt.Errorf("no broker addresses configured")
+	}
+	var err error
+	for _, addr := range fc.Addrs {
+		var c *stomp.Client
+		c, err = stomp.Connect(addr, fc.Config, fc.Transport)
+		if err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+type runner struct {
+	c       *stomp.Client
+	timeout time.Duration
+	msgs    chan *stomp.Frame
+}
+
+// receive forwards received messages to r.msgs until the
+// connection ends.
+func (r *runner) receive() {
+	batch := make([]*stomp.Frame, 16)
+	for {
+		n, _ := r.c.Receive(batch)
+		if n == 0 {
+			close(r.msgs)
+			return
+		}
+		for _, f := range batch[:n] {
+			r.msgs <- f
+		}
+	}
+}
+
+// wait runs f, failing if it does not return within the timeout,
+// as happens when a receipt never arrives.
+func (r *runner) wait(f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(r.timeout):
+		return fmt.Errorf("no receipt after %s", r.timeout)
+	}
+}
+
+func (r *runner) step(st step) (string, error) {
+	switch {
+	case st.Subscribe != nil:
+		s := st.Subscribe
+		mode := s.Mode
+		if mode == "" {
+			mode = stomp.AutoMode
+		}
+		var hdrs *map[string]string
+		if s.Headers != nil {
+			hdrs = &s.Headers
+		}
+		return "subscribe " + s.Destination, r.wait(func() error {
+			_, err := r.c.SubscribeWithHeaders(s.Destination, mode, hdrs, s.Receipt)
+			return err
+		})
+
+	case st.Publish != nil:
+		p := st.Publish
+		count := p.Count
+		if count == 0 {
+			count = 1
+		}
+		desc := fmt.Sprintf("publish %d to %s", count, p.Destination)
+		for i := 0; i < count; i++ {
+			var hdrs *map[string]string
+			if p.Headers != nil {
+				h := make(map[string]string, len(p.Headers))
+				for k, v := range p.Headers {
+					h[k] = v
+				}
+				hdrs = &h
+			}
+			err := r.wait(func() error {
+				return r.c.Send(p.Destination, hdrs, p.ContentType, strings.NewReader(p.Body), p.Receipt)
+			})
+			if err != nil {
+				return desc, fmt.Errorf("message %d: %v", i+1, err)
+			}
+		}
+		return desc, nil
+
+	case st.Expect != nil:
+		e := st.Expect
+		count := e.Count
+		if count == 0 {
+			count = 1
+		}
+		desc := fmt.Sprintf("expect %d", count)
+		if e.Destination != "" {
+			desc += " from " + e.Destination
+		}
+		return desc, r.expect(e, count)
+	}
+	return "", fmt.Errorf("empty step")
+}
+
+func (r *runner) expect(e *expectStep, count int) error {
+	timeout := time.After(e.Timeout.or(r.timeout))
+	got := 0
+	for got < count {
+		select {
+		case f, ok := <-r.msgs:
+			if !ok {
+				return fmt.Errorf("connection ended after %d of %d messages: %v", got, count, r.c.Err())
+			}
+			body, err := ioutil.ReadAll(f.Body)
+			if err != nil {
+				return err
+			}
+			if ack, ok := f.Headers["ack"]; ok {
+				err = r.c.Ack(ack, false)
+				if err != nil {
+					return err
+				}
+			}
+			if e.matches(f, string(body)) {
+				got++
+			}
+		case <-timeout:
+			return fmt.Errorf("received %d of %d matching messages", got, count)
+		}
+	}
+	return nil
+}
@@ -40,8 +40,52 @@ type Config struct {
 	// The password used to authenticate the client.
 	Passcode string
 
+	// Credentials, if non-nil, is called for each connection and
+	// its result is used in place of Login and Passcode.
+	Credentials func() (login string, passcode string, err error)
+
 	// The heart-beat configuration for the client and server connection.
 	Heartbeat Heartbeat
+
+	// IdleTimeout is the period without sends, acknowledgements or
+	// deliveries after which the client disconnects.
+	// Heart-beats do not count as activity. Zero means no timeout.
+	IdleTimeout time.Duration
+
+	// PriorityWindow is the number of received messages buffered
+	// so that they may be delivered to MsgCh ordered by their priority
+	// header, highest first. Buffered messages are not acknowledged, so
+	// ClientIndividualMode should be used to avoid acknowledging them
+	// cumulatively. Zero delivers messages in the order received.
+	PriorityWindow int
+
+	// DeliverAtHeader names a message header holding a scheduled delivery
+	// time, in milliseconds since the Unix epoch or RFC 3339 format.
+	// Messages scheduled in the future are held and delivered to MsgCh
+	// once their time arrives. Held messages are not acknowledged, so
+	// ClientIndividualMode should be used to avoid acknowledging them
+	// cumulatively. Empty disables deferred delivery.
+	DeliverAtHeader string
+
+	// Mirrors lists destinations whose sent or received messages
+	// are copied to another destination.
+	Mirrors []Mirror
+
+	// NackOnDisconnect sends a NACK for every message delivered on a
+	// ClientMode or ClientIndividualMode subscription and not yet
+	// acknowledged when the client disconnects, in delivery order,
+	// so the broker redelivers them promptly.
+	NackOnDisconnect bool
+
+	// HeaderUTF8 defines how received headers that are not valid UTF-8
+	// are handled. Sent headers must always be valid UTF-8.
+	HeaderUTF8 UTF8Policy
+
+	// RingBuffer is the capacity of a ring buffer used in place of MsgCh
+	// to hand messages to the consumer. Messages must then be read with
+	// Client.Receive, which dequeues them in batches. The capacity is
+	// rounded up to a power of two. Zero delivers messages on MsgCh.
+	RingBuffer int
 }
 
 // DefaultConfig is a default client configuration.
@@ -60,11 +104,21 @@ type TransportConfig struct {
 	// If TLSConfig is nil, then the connection will not used TLS.
 	TLSConfig *tls.Config
 
+	// GetTLSConfig, if non-nil, is called for each connection and
+	// its result is used in place of TLSConfig.
+	// A nil result means the connection will not use TLS.
+	GetTLSConfig func() (*tls.Config, error)
+
 	// TLSHandshakeTimeout defines the maximum time to
 	// wait for TLS handshake before timing out.
 	// Zero means no timeout.
 	// If TLSConfig is nil, the timeout will be ignored.
 	TLSHandshakeTimeout time.Duration
+
+	// Shaping optionally simulates network conditions on the connection.
+	// Shaping is applied beneath TLS.
+	// If Shaping is nil, the connection is used as is.
+	Shaping *Shaping
 }
 
 // DefaultTransportConfig defines the default transport config.
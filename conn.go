@@ -0,0 +1,53 @@
+package stomp
+
+import "io"
+
+// Conn is the public interface of a STOMP client connection.
+// Conn is implemented by Client, and by FakeConn for testing
+// applications without a broker.
+type Conn interface {
+	// Messages returns the channel from which MESSAGE frames may be read.
+	// A Client using Config.RingBuffer sends no messages on the channel;
+	// code that must work with any Conn should use Receive.
+	Messages() <-chan *Frame
+
+	// Receive moves up to len(batch) MESSAGE frames into batch, blocking
+	// until at least one is available. Receive returns zero and the reason
+	// the connection ended once all messages have been received.
+	// Receive works in every delivery mode and must not be mixed with
+	// reading from Messages.
+	Receive(batch []*Frame) (int, error)
+
+	// Errors returns the channel from which ERROR frames may be read.
+	Errors() <-chan *Frame
+
+	// Err returns the reason the connection ended, or nil while it is open.
+	Err() error
+
+	Send(dest string, hdrs *map[string]string, bodyType string, body io.Reader, receipt bool) error
+	SendMulti(dests []string, hdrs *map[string]string, bodyType string, body io.Reader, receipt bool) error
+	Ack(id string, receipt bool) error
+	Nack(id string, receipt bool) error
+	Subscribe(dest string, mode AckMode, receipt bool) (string, error)
+	SubscribeWithHeaders(dest string, mode AckMode, hdrs *map[string]string, receipt bool) (string, error)
+	Unsubscribe(id string, receipt bool) error
+	Begin(receipt bool) (*Tx, error)
+	Disconnect() error
+}
+
+var (
+	_ Conn = (*Client)(nil)
+	_ Conn = (*FakeConn)(nil)
+)
+
+// Messages returns MsgCh.
+// With Config.RingBuffer set, no messages are sent on the channel, which
+// is only closed when the connection ends; use Receive instead.
+func (c *Client) Messages() <-chan *Frame {
+	return c.MsgCh
+}
+
+// Errors returns ErrCh.
+func (c *Client) Errors() <-chan *Frame {
+	return c.ErrCh
+}
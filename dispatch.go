@@ -0,0 +1,193 @@
+package stomp
+
+import (
+	"container/heap"
+	"strconv"
+	"time"
+)
+
+// A stage reads MESSAGE frames from in and writes them to out,
+// closing out once in has been closed and drained.
+type stage func(in <-chan *Frame, out chan<- *Frame)
+
+// pipeline chains stages ahead of out and returns the channel
+// feeding the first stage. With no stages, out is returned.
+func pipeline(out chan<- *Frame, stages ...stage) chan<- *Frame {
+	for i := len(stages) - 1; i >= 0; i-- {
+		in := make(chan *Frame)
+		go stages[i](in, out)
+		out = in
+	}
+	return out
+}
+
+// defaultPriority is the JMS default message priority.
+const defaultPriority = 4
+
+func framePriority(f *Frame) int {
+	p, err := strconv.Atoi(f.Headers["priority"])
+	if err != nil {
+		return defaultPriority
+	}
+	return p
+}
+
+type prioritized struct {
+	f   *Frame
+	pri int
+	seq uint64
+}
+
+type priorityHeap []prioritized
+
+func (h priorityHeap) Len() int { return len(h) }
+
+func (h priorityHeap) Less(i, j int) bool {
+	if h[i].pri != h[j].pri {
+		return h[i].pri > h[j].pri
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h priorityHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *priorityHeap) Push(x interface{}) { *h = append(*h, x.(prioritized)) }
+
+func (h *priorityHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// prioritize returns a stage that buffers up to window messages and
+// delivers the highest priority buffered message first. Messages of
+// equal priority are delivered in the order received.
+func prioritize(window int) stage {
+	return func(in <-chan *Frame, out chan<- *Frame) {
+		defer close(out)
+
+		var q priorityHeap
+		var seq uint64
+		for in != nil || q.Len() > 0 {
+			var inc <-chan *Frame
+			if in != nil && q.Len() < window {
+				inc = in
+			}
+
+			var outc chan<- *Frame
+			var next *Frame
+			if q.Len() > 0 {
+				outc = out
+				next = q[0].f
+			}
+
+			select {
+			case f, ok := <-inc:
+				if !ok {
+					in = nil
+					continue
+				}
+				heap.Push(&q, prioritized{f: f, pri: framePriority(f), seq: seq})
+				seq++
+			case outc <- next:
+				heap.Pop(&q)
+			}
+		}
+	}
+}
+
+// parseDeliverAt parses a scheduled delivery time given either in
+// milliseconds since the Unix epoch or in RFC 3339 format.
+func parseDeliverAt(v string) (time.Time, bool) {
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), true
+	}
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+type scheduled struct {
+	f   *Frame
+	at  time.Time
+	seq uint64
+}
+
+type scheduleHeap []scheduled
+
+func (h scheduleHeap) Len() int { return len(h) }
+
+func (h scheduleHeap) Less(i, j int) bool {
+	if !h[i].at.Equal(h[j].at) {
+		return h[i].at.Before(h[j].at)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h scheduleHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *scheduleHeap) Push(x interface{}) { *h = append(*h, x.(scheduled)) }
+
+func (h *scheduleHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// deferDelivery returns a stage that holds messages carrying a future
+// time in header until that time arrives. Other messages pass through
+// in the order received. Messages still held when in is closed are
+// dropped, leaving them unacknowledged for the broker to redeliver.
+func deferDelivery(header string) stage {
+	return func(in <-chan *Frame, out chan<- *Frame) {
+		defer close(out)
+
+		var ready []*Frame
+		var held scheduleHeap
+		var seq uint64
+		for in != nil || len(ready) > 0 {
+			now := time.Now()
+			for held.Len() > 0 && !held[0].at.After(now) {
+				ready = append(ready, heap.Pop(&held).(scheduled).f)
+			}
+
+			var inc <-chan *Frame
+			if in != nil && len(ready) == 0 {
+				inc = in
+			}
+
+			var outc chan<- *Frame
+			var next *Frame
+			if len(ready) > 0 {
+				outc = out
+				next = ready[0]
+			}
+
+			var due <-chan time.Time
+			if held.Len() > 0 {
+				due = time.After(held[0].at.Sub(now))
+			}
+
+			select {
+			case f, ok := <-inc:
+				if !ok {
+					in = nil
+					continue
+				}
+				at, ok := parseDeliverAt(f.Headers[header])
+				if !ok || !at.After(now) {
+					ready = append(ready, f)
+					continue
+				}
+				heap.Push(&held, scheduled{f: f, at: at, seq: seq})
+				seq++
+			case outc <- next:
+				ready = ready[1:]
+			case <-due:
+			}
+		}
+	}
+}
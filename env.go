@@ -0,0 +1,133 @@
+package stomp
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lookupDuration reads a duration such as 10s from the environment variable key.
+func lookupDuration(key string) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("stomp: invalid %s %q", key, v)
+	}
+	return d, nil
+}
+
+// lookupBool reads a boolean such as true or 1 from the environment variable key.
+func lookupBool(key string) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("stomp: invalid %s %q", key, v)
+	}
+	return b, nil
+}
+
+// ConfigFromEnv returns a client configuration read from the environment.
+// Unset variables keep the values of DefaultConfig.
+//
+//	STOMP_HOST             virtual host
+//	STOMP_LOGIN            user identifier
+//	STOMP_PASSCODE         password
+//	STOMP_HEARTBEAT_SEND   client heart-beat interval, e.g. 10s
+//	STOMP_HEARTBEAT_RECV   expected server heart-beat interval, e.g. 10s
+//	STOMP_IDLE_TIMEOUT     idle timeout, e.g. 5m
+func ConfigFromEnv() (*Config, error) {
+	conf := *DefaultConfig
+
+	if v := os.Getenv("STOMP_HOST"); v != "" {
+		conf.Host = v
+	}
+	conf.Login = os.Getenv("STOMP_LOGIN")
+	conf.Passcode = os.Getenv("STOMP_PASSCODE")
+
+	var err error
+	conf.Heartbeat.Send, err = lookupDuration("STOMP_HEARTBEAT_SEND")
+	if err != nil {
+		return nil, err
+	}
+	conf.Heartbeat.Recv, err = lookupDuration("STOMP_HEARTBEAT_RECV")
+	if err != nil {
+		return nil, err
+	}
+	conf.IdleTimeout, err = lookupDuration("STOMP_IDLE_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+
+	return &conf, nil
+}
+
+// TransportConfigFromEnv returns a transport configuration read from the environment.
+// TLS is used if STOMP_TLS is true. If STOMP_TLS is unset, TLS is used
+// when any other STOMP_TLS_* variable is set; an explicitly false
+// STOMP_TLS disables TLS regardless of the other variables.
+//
+//	STOMP_TLS                        use TLS, e.g. true
+//	STOMP_TLS_CA_FILE                PEM bundle of certificate authorities
+//	STOMP_TLS_CERT_FILE              PEM client certificate
+//	STOMP_TLS_KEY_FILE               PEM client private key
+//	STOMP_TLS_SERVER_NAME            server name used for verification
+//	STOMP_TLS_INSECURE_SKIP_VERIFY   skip server verification, e.g. true
+//	STOMP_TLS_HANDSHAKE_TIMEOUT      handshake timeout, e.g. 10s
+func TransportConfigFromEnv() (*TransportConfig, error) {
+	tr := *DefaultTransportConfig
+
+	useTLS, err := lookupBool("STOMP_TLS")
+	if err != nil {
+		return nil, err
+	}
+
+	files := TLSFiles{
+		CAFile:   os.Getenv("STOMP_TLS_CA_FILE"),
+		CertFile: os.Getenv("STOMP_TLS_CERT_FILE"),
+		KeyFile:  os.Getenv("STOMP_TLS_KEY_FILE"),
+	}
+	serverName := os.Getenv("STOMP_TLS_SERVER_NAME")
+	insecure, err := lookupBool("STOMP_TLS_INSECURE_SKIP_VERIFY")
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := lookupDuration("STOMP_TLS_HANDSHAKE_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+
+	if os.Getenv("STOMP_TLS") == "" {
+		useTLS = timeout != 0 || insecure || serverName != "" || files != (TLSFiles{})
+	}
+	if useTLS {
+		tr.TLSConfig, err = files.Config()
+		if err != nil {
+			return nil, err
+		}
+		tr.TLSConfig.ServerName = serverName
+		tr.TLSConfig.InsecureSkipVerify = insecure
+		tr.TLSHandshakeTimeout = timeout
+	}
+
+	return &tr, nil
+}
+
+// AddrsFromEnv returns the broker addresses listed, comma separated,
+// in STOMP_ADDRS. AddrsFromEnv returns nil if none are set.
+func AddrsFromEnv() []string {
+	var addrs []string
+	for _, a := range strings.Split(os.Getenv("STOMP_ADDRS"), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
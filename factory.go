@@ -0,0 +1,129 @@
+package stomp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrConnLimit is returned when a factory has reached its connection limit.
+var ErrConnLimit = errors.New("stomp: factory connection limit reached")
+
+// FactoryStats reports connection counts for a factory.
+type FactoryStats struct {
+	// Active is the number of open connections per virtual host.
+	Active map[string]int
+
+	// Opened is the total number of connections established.
+	Opened int
+
+	// Failed is the total number of failed connection attempts.
+	Failed int
+}
+
+// Factory creates clients for many virtual hosts of a single broker.
+// Clients share the factory's address and transport configuration,
+// and are subject to a common connection limit.
+type Factory struct {
+	addr     string
+	tr       *TransportConfig
+	maxConns int
+
+	lock   sync.Mutex
+	hosts  map[string]*Config
+	active map[string]int
+	total  int
+	opened int
+	failed int
+}
+
+// NewFactory creates a factory connecting to addr with the
+// transport configuration tr.
+// A nil tr value behaves as it does for Connect.
+// A maxConns value of zero means no connection limit.
+func NewFactory(addr string, tr *TransportConfig, maxConns int) *Factory {
+	return &Factory{
+		addr:     addr,
+		tr:       tr,
+		maxConns: maxConns,
+		hosts:    make(map[string]*Config),
+		active:   make(map[string]int),
+	}
+}
+
+// Register adds the client configuration for the virtual host conf.Host,
+// replacing any existing configuration for that host.
+func (f *Factory) Register(conf *Config) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.hosts[conf.Host] = conf
+}
+
+// Connect creates a client for the registered virtual host.
+// Connect returns ErrConnLimit if the connection limit has been reached.
+func (f *Factory) Connect(host string) (*Client, error) {
+	f.lock.Lock()
+	conf, ok := f.hosts[host]
+	if !ok {
+		f.lock.Unlock()
+		return nil, fmt.Errorf("stomp: unknown virtual host %s", host)
+	}
+	if f.maxConns > 0 && f.total >= f.maxConns {
+		f.lock.Unlock()
+		return nil, ErrConnLimit
+	}
+	// Reserve the connection before dialing so concurrent
+	// calls cannot exceed the limit.
+	f.total++
+	f.lock.Unlock()
+
+	c, err := Connect(f.addr, conf, f.tr)
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err != nil {
+		f.total--
+		f.failed++
+		return nil, err
+	}
+	f.opened++
+	f.active[host]++
+
+	go f.release(host, c)
+
+	return c, nil
+}
+
+// release frees the connection slot of c once its connection ends or
+// Disconnect returns, whichever happens first. Waiting on Disconnect too
+// avoids leaking the slot when the read loop is blocked on an undrained MsgCh.
+func (f *Factory) release(host string, c *Client) {
+	select {
+	case <-c.receipts.closed:
+	case <-c.disconnected:
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.total--
+	f.active[host]--
+	if f.active[host] == 0 {
+		delete(f.active, host)
+	}
+}
+
+// Stats returns a snapshot of the factory's connection counts.
+func (f *Factory) Stats() FactoryStats {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	s := FactoryStats{
+		Active: make(map[string]int, len(f.active)),
+		Opened: f.opened,
+		Failed: f.failed,
+	}
+	for k, v := range f.active {
+		s.Active[k] = v
+	}
+	return s
+}
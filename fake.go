@@ -0,0 +1,282 @@
+package stomp
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// ErrFakeClosed is returned by a FakeConn that has been disconnected.
+var ErrFakeClosed = errors.New("stomp: fake connection is closed")
+
+// FakeConn is an in-memory Conn for testing applications without a broker.
+// FakeConn records the frames it would have sent, and tests deliver
+// messages and errors to it. Receipts are satisfied immediately.
+type FakeConn struct {
+	// Fail, if non-nil, is called with each outgoing frame.
+	// A non-nil result is returned to the caller and the frame is not recorded.
+	Fail func(f *Frame) error
+
+	lock     sync.Mutex
+	frames   []*Frame
+	closed   bool
+	closeErr error
+	ids      int
+	receipts *receipts
+	msgs     chan *Frame
+	errs     chan *Frame
+}
+
+// NewFakeConn creates a fake connection.
+// buffer is the capacity of the message and error channels.
+func NewFakeConn(buffer int) *FakeConn {
+	return &FakeConn{
+		receipts: newReceipts(),
+		msgs:     make(chan *Frame, buffer),
+		errs:     make(chan *Frame, buffer),
+	}
+}
+
+// Frames returns the frames recorded so far, in order.
+func (c *FakeConn) Frames() []*Frame {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	frames := make([]*Frame, len(c.frames))
+	copy(frames, c.frames)
+	return frames
+}
+
+// Deliver makes f available from Messages.
+// Deliver blocks until there is room in the channel.
+// Deliver must not be called once the connection has ended.
+func (c *FakeConn) Deliver(f *Frame) {
+	c.msgs <- f
+}
+
+// DeliverError makes f available from Errors and ends the connection,
+// as an ERROR frame from a broker would.
+func (c *FakeConn) DeliverError(f *Frame) {
+	c.errs <- f
+	c.close(errors.New("stomp: server error " + f.Headers["message"]))
+}
+
+func (c *FakeConn) close(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.closeErr = err
+	c.receipts.Close(err)
+	close(c.msgs)
+}
+
+func (c *FakeConn) nextID() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ids++
+	return strconv.Itoa(c.ids)
+}
+
+// record records f, satisfying its receipt if requested.
+func (c *FakeConn) record(f *Frame, receipt *string) error {
+	if receipt != nil {
+		f.Headers["receipt"] = *receipt
+	}
+
+	if c.Fail != nil {
+		if err := c.Fail(f); err != nil {
+			return err
+		}
+	}
+
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return ErrFakeClosed
+	}
+	c.frames = append(c.frames, f)
+	c.lock.Unlock()
+
+	if receipt != nil {
+		c.receipts.Clear(*receipt)
+	}
+	return nil
+}
+
+func (c *FakeConn) do(receipt bool, f func(rid *string) error) error {
+	if receipt {
+		return doWithReceipt(c.receipts, func(rid string) error {
+			return f(&rid)
+		})
+	}
+	return f(nil)
+}
+
+func fakeFrame(cmd string, hdrs map[string]string) *Frame {
+	f := NewFrame(cmd, nil)
+	for k, v := range hdrs {
+		f.Headers[k] = v
+	}
+	return f
+}
+
+// Messages returns the channel of delivered messages.
+func (c *FakeConn) Messages() <-chan *Frame {
+	return c.msgs
+}
+
+// Receive moves up to len(batch) delivered messages into batch,
+// as Client.Receive does.
+func (c *FakeConn) Receive(batch []*Frame) (int, error) {
+	if len(batch) == 0 {
+		return 0, nil
+	}
+	n := receiveChan(c.msgs, batch)
+	if n == 0 {
+		return 0, c.Err()
+	}
+	return n, nil
+}
+
+// Errors returns the channel of delivered errors.
+func (c *FakeConn) Errors() <-chan *Frame {
+	return c.errs
+}
+
+// Err returns the reason the connection ended, or nil while it is open.
+func (c *FakeConn) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closeErr
+}
+
+// Send records a SEND frame.
+func (c *FakeConn) Send(dest string, hdrs *map[string]string, bodyType string, body io.Reader, receipt bool) error {
+	f, err := makeSendFrame(dest, hdrs, bodyType, body)
+	if err != nil {
+		return err
+	}
+	return c.do(receipt, func(rid *string) error {
+		return c.record(f, rid)
+	})
+}
+
+// SendMulti records a SEND frame to a composite destination.
+func (c *FakeConn) SendMulti(dests []string, hdrs *map[string]string, bodyType string, body io.Reader, receipt bool) error {
+	dest, err := compositeDestination(dests)
+	if err != nil {
+		return err
+	}
+	return c.Send(dest, hdrs, bodyType, body, receipt)
+}
+
+// Ack records an ACK frame.
+func (c *FakeConn) Ack(id string, receipt bool) error {
+	return c.do(receipt, func(rid *string) error {
+		return c.record(fakeFrame("ACK", map[string]string{"id": id}), rid)
+	})
+}
+
+// Nack records a NACK frame.
+func (c *FakeConn) Nack(id string, receipt bool) error {
+	return c.do(receipt, func(rid *string) error {
+		return c.record(fakeFrame("NACK", map[string]string{"id": id}), rid)
+	})
+}
+
+// Subscribe records a SUBSCRIBE frame.
+// Subscription IDs are sequential, starting from 1.
+func (c *FakeConn) Subscribe(dest string, mode AckMode, receipt bool) (string, error) {
+	return c.SubscribeWithHeaders(dest, mode, nil, receipt)
+}
+
+// SubscribeWithHeaders records a SUBSCRIBE frame with the additional headers hdrs.
+func (c *FakeConn) SubscribeWithHeaders(dest string, mode AckMode, hdrs *map[string]string, receipt bool) (string, error) {
+	id := c.nextID()
+	f := NewFrame("SUBSCRIBE", nil)
+	if hdrs != nil {
+		for k, v := range *hdrs {
+			f.Headers[k] = v
+		}
+	}
+	f.Headers["destination"] = dest
+	f.Headers["id"] = id
+	f.Headers["ack"] = string(mode)
+	err := c.do(receipt, func(rid *string) error {
+		return c.record(f, rid)
+	})
+	return id, err
+}
+
+// Unsubscribe records an UNSUBSCRIBE frame.
+func (c *FakeConn) Unsubscribe(id string, receipt bool) error {
+	return c.do(receipt, func(rid *string) error {
+		return c.record(fakeFrame("UNSUBSCRIBE", map[string]string{"id": id}), rid)
+	})
+}
+
+// Begin records a BEGIN frame and returns a transaction whose
+// frames are recorded by c.
+// Transaction IDs are sequential, starting from 1.
+func (c *FakeConn) Begin(receipt bool) (*Tx, error) {
+	tid := c.nextID()
+	err := c.do(receipt, func(rid *string) error {
+		return c.record(fakeFrame("BEGIN", map[string]string{"transaction": tid}), rid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &Tx{
+		tid:       tid,
+		done:      false,
+		receipts:  c.receipts,
+		activity:  newActivity(),
+		subs:      newSubscriptions(),
+		transport: fakeTx{c},
+	}
+	return tx, nil
+}
+
+// Disconnect records a DISCONNECT frame and closes the connection.
+func (c *FakeConn) Disconnect() error {
+	err := c.record(fakeFrame("DISCONNECT", nil), nil)
+	if err == ErrFakeClosed {
+		return nil
+	}
+	c.close(errClosed)
+	return err
+}
+
+// fakeTx records the frames of a transaction on a FakeConn.
+type fakeTx struct {
+	c *FakeConn
+}
+
+func (t fakeTx) TxCommit(tid string, receipt *string) error {
+	return t.c.record(fakeFrame("COMMIT", map[string]string{"transaction": tid}), receipt)
+}
+
+func (t fakeTx) TxAbort(tid string, receipt *string) error {
+	return t.c.record(fakeFrame("ABORT", map[string]string{"transaction": tid}), receipt)
+}
+
+func (t fakeTx) TxSend(tid string, dest string, hdrs *map[string]string, bodyType string, body io.Reader) error {
+	f, err := makeSendFrame(dest, hdrs, bodyType, body)
+	if err != nil {
+		return err
+	}
+	f.Headers["transaction"] = tid
+	return t.c.record(f, nil)
+}
+
+func (t fakeTx) TxAck(tid string, id string) error {
+	return t.c.record(fakeFrame("ACK", map[string]string{"id": id, "transaction": tid}), nil)
+}
+
+func (t fakeTx) TxNack(tid string, id string) error {
+	return t.c.record(fakeFrame("NACK", map[string]string{"id": id, "transaction": tid}), nil)
+}
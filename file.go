@@ -0,0 +1,192 @@
+package stomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Subscription defines a subscription to be made by a client.
+type Subscription struct {
+	Destination string            `json:"destination"`
+	Mode        AckMode           `json:"ack"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// FileConfig is a configuration loaded with LoadConfig.
+type FileConfig struct {
+	// Addrs are the broker addresses.
+	Addrs []string
+
+	// Config is the client configuration.
+	Config *Config
+
+	// Transport is the transport configuration.
+	Transport *TransportConfig
+
+	// Subscriptions are the subscriptions to make once connected.
+	Subscriptions []Subscription
+}
+
+// duration is a time.Duration encoded as a string such as 10s.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("stomp: invalid duration %q", s)
+	}
+	*d = duration(v)
+	return nil
+}
+
+type fileConfig struct {
+	Addrs     []string `json:"addrs"`
+	Host      string   `json:"host"`
+	Login     string   `json:"login"`
+	Passcode  string   `json:"passcode"`
+	Heartbeat struct {
+		Send duration `json:"send"`
+		Recv duration `json:"recv"`
+	} `json:"heartbeat"`
+	IdleTimeout     duration `json:"idle_timeout"`
+	PriorityWindow  int      `json:"priority_window"`
+	DeliverAtHeader string   `json:"deliver_at_header"`
+	TLS             *struct {
+		CAFile             string   `json:"ca_file"`
+		CertFile           string   `json:"cert_file"`
+		KeyFile            string   `json:"key_file"`
+		ServerName         string   `json:"server_name"`
+		InsecureSkipVerify bool     `json:"insecure_skip_verify"`
+		HandshakeTimeout   duration `json:"handshake_timeout"`
+	} `json:"tls"`
+	Subscriptions []Subscription `json:"subscriptions"`
+}
+
+// LoadConfig loads a JSON configuration file from path.
+// Relative TLS file paths are resolved against the directory of path.
+//
+//	{
+//		"addrs": ["broker:61613"],
+//		"host": "/",
+//		"login": "guest",
+//		"passcode": "guest",
+//		"heartbeat": {"send": "10s", "recv": "10s"},
+//		"idle_timeout": "5m",
+//		"priority_window": 0,
+//		"deliver_at_header": "",
+//		"tls": {
+//			"ca_file": "ca.pem",
+//			"cert_file": "client.pem",
+//			"key_file": "client.key",
+//			"server_name": "broker",
+//			"insecure_skip_verify": false,
+//			"handshake_timeout": "10s"
+//		},
+//		"subscriptions": [
+//			{"destination": "/queue/a", "ack": "client-individual"},
+//			{"destination": "/topic/b", "headers": {"selector": "region = 'EU'"}}
+//		]
+//	}
+func LoadConfig(path string) (*FileConfig, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return nil, fmt.Errorf("stomp: YAML configuration is not supported, use JSON")
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fc fileConfig
+	err = json.Unmarshal(buf, &fc)
+	if err != nil {
+		return nil, fmt.Errorf("stomp: unable to parse %s: %v", path, err)
+	}
+
+	conf := *DefaultConfig
+	if fc.Host != "" {
+		conf.Host = fc.Host
+	}
+	conf.Login = fc.Login
+	conf.Passcode = fc.Passcode
+	conf.Heartbeat = Heartbeat{
+		Send: time.Duration(fc.Heartbeat.Send),
+		Recv: time.Duration(fc.Heartbeat.Recv),
+	}
+	conf.IdleTimeout = time.Duration(fc.IdleTimeout)
+	conf.PriorityWindow = fc.PriorityWindow
+	conf.DeliverAtHeader = fc.DeliverAtHeader
+
+	tr := *DefaultTransportConfig
+	if fc.TLS != nil {
+		dir := filepath.Dir(path)
+		files := TLSFiles{
+			CAFile:   resolvePath(dir, fc.TLS.CAFile),
+			CertFile: resolvePath(dir, fc.TLS.CertFile),
+			KeyFile:  resolvePath(dir, fc.TLS.KeyFile),
+		}
+		tr.TLSConfig, err = files.Config()
+		if err != nil {
+			return nil, err
+		}
+		tr.TLSConfig.ServerName = fc.TLS.ServerName
+		tr.TLSConfig.InsecureSkipVerify = fc.TLS.InsecureSkipVerify
+		tr.TLSHandshakeTimeout = time.Duration(fc.TLS.HandshakeTimeout)
+	}
+
+	for i, s := range fc.Subscriptions {
+		if s.Destination == "" {
+			return nil, fmt.Errorf("stomp: subscription %d has no destination", i)
+		}
+		switch s.Mode {
+		case "":
+			fc.Subscriptions[i].Mode = AutoMode
+		case AutoMode, ClientMode, ClientIndividualMode:
+		default:
+			return nil, fmt.Errorf("stomp: subscription %d has bad ack mode %s", i, s.Mode)
+		}
+	}
+
+	return &FileConfig{
+		Addrs:         fc.Addrs,
+		Config:        &conf,
+		Transport:     &tr,
+		Subscriptions: fc.Subscriptions,
+	}, nil
+}
+
+func resolvePath(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Subscribe makes each of the configured subscriptions on c.
+// Subscribe returns the subscription IDs, in order.
+// A true receipt value will use a receipt for each frame.
+func (f *FileConfig) Subscribe(c Conn, receipt bool) ([]string, error) {
+	ids := make([]string, 0, len(f.Subscriptions))
+	for _, s := range f.Subscriptions {
+		var hdrs *map[string]string
+		if s.Headers != nil {
+			hdrs = &s.Headers
+		}
+		id, err := c.SubscribeWithHeaders(s.Destination, s.Mode, hdrs, receipt)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
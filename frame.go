@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // Frame is a STOMP frame.
@@ -50,13 +51,26 @@ func (e *Encoder) Encode(f *Frame) error {
 		return err
 	}
 
-	_, err := fmt.Fprintf(e.w, "%s\n", f.Command)
+	if f.Command == "" || strings.ContainsAny(f.Command, "\r\n") {
+		return fmt.Errorf("stomp: invalid frame command %q", f.Command)
+	}
+
+	err := validateHeaders(f)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(e.w, "%s\n", f.Command)
 	if err != nil {
 		return err
 	}
 
 	if f.Headers != nil {
+		escape := escapeFrame(f.Command)
 		for k, v := range f.Headers {
+			if escape {
+				k, v = headerEscaper.Replace(k), headerEscaper.Replace(v)
+			}
 			_, err = fmt.Fprintf(e.w, "%s:%s\n", k, v)
 			if err != nil {
 				return err
@@ -88,68 +102,253 @@ func (e *Encoder) Encode(f *Frame) error {
 	return nil
 }
 
+// validateHeaders checks that the headers of f are valid UTF-8 without
+// NUL octets, and can be represented in a frame of its command.
+// Headers are validated before encoding so that a frame is never
+// partially written.
+func validateHeaders(f *Frame) error {
+	escape := escapeFrame(f.Command)
+	for k, v := range f.Headers {
+		if !utf8.ValidString(k) || !utf8.ValidString(v) {
+			return fmt.Errorf("stomp: header %q is not valid UTF-8", k)
+		}
+		if strings.IndexByte(k, 0) >= 0 || strings.IndexByte(v, 0) >= 0 {
+			return fmt.Errorf("stomp: header %q contains a NUL octet", k)
+		}
+		if !escape && (strings.ContainsAny(k, ":\r\n") || strings.ContainsAny(v, "\r\n")) {
+			return fmt.Errorf("stomp: header %q cannot be encoded in a %s frame", k, f.Command)
+		}
+	}
+	return nil
+}
+
+// UTF8Policy defines how a Decoder handles headers that are not valid UTF-8.
+type UTF8Policy int
+
+const (
+	// UTF8PassThrough accepts invalid headers unchanged.
+	UTF8PassThrough UTF8Policy = iota
+
+	// UTF8Replace replaces invalid sequences with the Unicode replacement character.
+	UTF8Replace
+
+	// UTF8Reject fails decoding of frames with invalid headers.
+	UTF8Reject
+)
+
+const (
+	// maxLineSize is the maximum size of a command or header line.
+	maxLineSize = 1 << 16
+
+	// maxHeaders is the maximum number of headers in a single frame.
+	maxHeaders = 1 << 10
+
+	// maxBodySize is the maximum size of a frame body. It is above
+	// the default frame size limits of common brokers.
+	maxBodySize = 1 << 27
+)
+
+// escapeFrame reports whether headers in frames with command cmd
+// are subject to STOMP 1.2 value escaping.
+func escapeFrame(cmd string) bool {
+	return cmd != "CONNECT" && cmd != "CONNECTED"
+}
+
+var headerEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"\r", "\\r",
+	"\n", "\\n",
+	":", "\\c",
+)
+
+func unescapeHeader(s string) (string, error) {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s, nil
+	}
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			buf = append(buf, s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", fmt.Errorf("stomp: unterminated header escape")
+		}
+		switch s[i] {
+		case 'r':
+			buf = append(buf, '\r')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'c':
+			buf = append(buf, ':')
+		case '\\':
+			buf = append(buf, '\\')
+		default:
+			return "", fmt.Errorf("stomp: invalid header escape \\%c", s[i])
+		}
+	}
+	return string(buf), nil
+}
+
 // Decoder reads frames from an input stream.
 type Decoder struct {
-	r *bufio.Reader
+	r    *bufio.Reader
+	utf8 UTF8Policy
 }
 
 // NewDecoder creates a new decoder with input stream r.
+// The decoder uses UTF8PassThrough.
 func NewDecoder(r io.Reader) *Decoder {
 	return &Decoder{r: bufio.NewReader(r)}
 }
 
+// SetUTF8Policy sets how headers that are not valid UTF-8 are handled.
+func (d *Decoder) SetUTF8Policy(p UTF8Policy) {
+	d.utf8 = p
+}
+
+func (d *Decoder) checkUTF8(s string) (string, error) {
+	if d.utf8 == UTF8PassThrough || utf8.ValidString(s) {
+		return s, nil
+	}
+	if d.utf8 == UTF8Replace {
+		return strings.ToValidUTF8(s, string(utf8.RuneError)), nil
+	}
+	return "", fmt.Errorf("stomp: frame header %q is not valid UTF-8", s)
+}
+
+// readLine reads a single EOL terminated line, stripping the EOL.
+// Lines longer than maxLineSize are rejected.
+func (d *Decoder) readLine() (string, error) {
+	var line []byte
+	for {
+		b, err := d.r.ReadSlice('\n')
+		if len(line)+len(b) > maxLineSize {
+			return "", fmt.Errorf("stomp: frame line exceeds %d bytes", maxLineSize)
+		}
+		line = append(line, b...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		line = bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})
+		return string(line), nil
+	}
+}
+
+// readBody reads a body terminated by NUL, stripping the NUL.
+// Bodies longer than maxBodySize are rejected.
+func (d *Decoder) readBody() ([]byte, error) {
+	var body []byte
+	for {
+		b, err := d.r.ReadSlice(0)
+		if len(body)+len(b) > maxBodySize+1 {
+			return nil, fmt.Errorf("stomp: frame body exceeds %d bytes", maxBodySize)
+		}
+		body = append(body, b...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return body[:len(body)-1], nil
+	}
+}
+
 // Decode decodes a frame from the input stream.
 func (d *Decoder) Decode(f *Frame) error {
-	c, err := d.r.ReadString('\n')
+	c, err := d.readLine()
 	if err != nil {
 		return err
 	}
 
-	if c == "\n" {
+	if c == "" {
 		f.Command = "HEARTBEAT"
 		return nil
 	}
-	c = strings.Trim(c, "\r\n")
+	if strings.ContainsAny(c, "\r\n") {
+		return fmt.Errorf("stomp: invalid frame command %q", c)
+	}
 
 	hdrs := make(map[string]string)
-	for {
-		h, err := d.r.ReadString('\n')
+	for n := 0; ; n++ {
+		h, err := d.readLine()
 		if err != nil {
 			return err
 		}
 
-		if h == "\n" {
+		if h == "" {
 			break
 		}
 
-		h = strings.Trim(h, "\n")
-		m := strings.Split(h, ":")
+		if n == maxHeaders {
+			return fmt.Errorf("stomp: frame exceeds %d headers", maxHeaders)
+		}
+
+		m := strings.SplitN(h, ":", 2)
 		if len(m) != 2 {
 			return fmt.Errorf("stomp: unable to decode frame header")
 		}
-		hdrs[m[0]] = m[1]
+		k, v := m[0], m[1]
+		if escapeFrame(c) {
+			k, err = unescapeHeader(k)
+			if err != nil {
+				return err
+			}
+			v, err = unescapeHeader(v)
+			if err != nil {
+				return err
+			}
+		}
+		k, err = d.checkUTF8(k)
+		if err != nil {
+			return err
+		}
+		v, err = d.checkUTF8(v)
+		if err != nil {
+			return err
+		}
+
+		// Only the first occurrence of a repeated header is used.
+		if _, ok := hdrs[k]; !ok {
+			hdrs[k] = v
+		}
 	}
 
-	body := []byte{0}
+	var body []byte
 	if length, ok := hdrs["content-length"]; ok {
 		n, err := strconv.Atoi(length)
-		if err != nil {
-			return err
+		if err != nil || n < 0 {
+			return fmt.Errorf("stomp: invalid content-length %q", length)
+		}
+		if n > maxBodySize {
+			return fmt.Errorf("stomp: frame body exceeds %d bytes", maxBodySize)
 		}
 
 		body, err = ioutil.ReadAll(io.LimitReader(d.r, int64(n)))
 		if err != nil {
 			return err
 		}
-	}
-
-	b, err := d.r.ReadBytes(0)
-	if err != nil {
-		return err
-	}
+		if len(body) != n {
+			return io.ErrUnexpectedEOF
+		}
 
-	if !bytes.Equal(b, []byte{0}) {
-		body = append(body, b...)
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != 0 {
+			return fmt.Errorf("stomp: frame body exceeds content-length")
+		}
+	} else {
+		body, err = d.readBody()
+		if err != nil {
+			return err
+		}
 	}
 
 	f.Command = c
@@ -0,0 +1,587 @@
+// Package gateway multiplexes many downstream STOMP client connections
+// over a small number of upstream broker connections.
+//
+// Downstream subscription IDs, transaction IDs and receipts are rewritten
+// so that sessions sharing an upstream connection cannot observe one another.
+// Each downstream session is bound to a single upstream connection for its
+// lifetime, so message acknowledgements always reach the connection that
+// delivered the message. Sessions may only acknowledge messages delivered
+// to them.
+//
+// Downstream receipts are sent once the broker has confirmed the frame,
+// except for frames within a transaction, which are forwarded without an
+// upstream receipt and confirmed as soon as they are sent. The broker
+// only acts on them when the transaction is committed, and COMMIT is
+// confirmed upstream.
+package gateway
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/djoyahoy/stomp"
+)
+
+// sessionQueue bounds the messages waiting to be written to a session.
+// Sessions that fall further behind are disconnected so that they do not
+// hold up the other sessions of their upstream connection.
+const sessionQueue = 64
+
+// routeBatch is the most messages received from an upstream at once.
+const routeBatch = 16
+
+// Gateway accepts downstream STOMP connections and serves them over
+// upstream clients. The gateway must be the only receiver of the upstream
+// clients' messages and errors. Messages are read with Client.Receive, so
+// clients using Config.RingBuffer are supported.
+//
+// The gateway does not authenticate downstream sessions: the login and
+// passcode headers of their CONNECT frames are ignored, and all sessions
+// act with the credentials of the upstream clients. Listeners should be
+// restricted to trusted clients.
+type Gateway struct {
+	lock      sync.Mutex
+	upstreams []*upstream
+	sessions  map[*session]struct{}
+	listeners map[net.Listener]struct{}
+	closed    bool
+}
+
+// New creates a gateway serving downstream connections over the
+// already connected upstream clients.
+func New(clients ...*stomp.Client) *Gateway {
+	g := &Gateway{
+		sessions:  make(map[*session]struct{}),
+		listeners: make(map[net.Listener]struct{}),
+	}
+	for _, c := range clients {
+		u := &upstream{
+			client: c,
+			subs:   make(map[string]subscriber),
+		}
+		g.upstreams = append(g.upstreams, u)
+		go u.route()
+	}
+	return g
+}
+
+// Serve accepts downstream connections on l until l is closed
+// or the gateway is closed.
+func (g *Gateway) Serve(l net.Listener) error {
+	g.lock.Lock()
+	if g.closed {
+		g.lock.Unlock()
+		return fmt.Errorf("gateway: closed")
+	}
+	g.listeners[l] = struct{}{}
+	g.lock.Unlock()
+
+	defer func() {
+		g.lock.Lock()
+		delete(g.listeners, l)
+		g.lock.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go g.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single downstream connection until it disconnects.
+func (g *Gateway) ServeConn(conn io.ReadWriteCloser) {
+	u := g.pick()
+	if u == nil {
+		conn.Close()
+		return
+	}
+
+	s := &session{
+		conn:     conn,
+		enc:      stomp.NewEncoder(conn),
+		dec:      stomp.NewDecoder(conn),
+		upstream: u,
+		subs:     make(map[string]string),
+		txs:      make(map[string]*stomp.Tx),
+		txAcks:   make(map[string][]string),
+		acks:     make(map[string]pendingAck),
+		out:      make(chan *stomp.Frame, sessionQueue),
+		done:     make(chan struct{}),
+	}
+
+	g.lock.Lock()
+	if g.closed {
+		g.lock.Unlock()
+		conn.Close()
+		u.release()
+		return
+	}
+	g.sessions[s] = struct{}{}
+	g.lock.Unlock()
+
+	go s.writeQueued()
+	s.serve()
+	close(s.done)
+
+	g.lock.Lock()
+	delete(g.sessions, s)
+	g.lock.Unlock()
+	u.release()
+}
+
+// Close stops accepting connections and closes all downstream sessions.
+// The upstream clients are not disconnected.
+func (g *Gateway) Close() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.closed = true
+	for l := range g.listeners {
+		l.Close()
+	}
+	for s := range g.sessions {
+		s.conn.Close()
+	}
+	return nil
+}
+
+// pick returns the upstream with the fewest sessions.
+func (g *Gateway) pick() *upstream {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	var best *upstream
+	var bestN int
+	for _, u := range g.upstreams {
+		u.lock.Lock()
+		n, done := u.sessions, u.done
+		u.lock.Unlock()
+		if done {
+			continue
+		}
+		if best == nil || n < bestN {
+			best, bestN = u, n
+		}
+	}
+	if best != nil {
+		best.lock.Lock()
+		best.sessions++
+		best.lock.Unlock()
+	}
+	return best
+}
+
+// subscriber identifies a downstream subscription.
+type subscriber struct {
+	s    *session
+	id   string
+	mode stomp.AckMode
+}
+
+// upstream is an upstream client shared by many sessions.
+type upstream struct {
+	client *stomp.Client
+
+	lock     sync.Mutex
+	subs     map[string]subscriber
+	sessions int
+	done     bool
+	next     uint64
+}
+
+// nextID returns a new upstream subscription ID.
+// The caller must hold u.lock.
+func (u *upstream) nextID() string {
+	u.next++
+	return "gateway-" + strconv.FormatUint(u.next, 10)
+}
+
+func (u *upstream) release() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.sessions--
+}
+
+// route delivers upstream messages to their downstream subscriptions.
+// Messages are queued for each session without blocking; a session whose
+// queue is full is closed. Once the upstream connection ends, all of its
+// sessions are closed.
+func (u *upstream) route() {
+	batch := make([]*stomp.Frame, routeBatch)
+	for {
+		n, _ := u.client.Receive(batch)
+		if n == 0 {
+			break
+		}
+		for _, f := range batch[:n] {
+			u.deliver(f)
+		}
+	}
+
+	u.lock.Lock()
+	u.done = true
+	sessions := make(map[*session]struct{})
+	for _, sub := range u.subs {
+		sessions[sub.s] = struct{}{}
+	}
+	u.lock.Unlock()
+
+	for s := range sessions {
+		go s.fail("upstream connection lost")
+	}
+}
+
+// deliver queues f for its downstream subscription, if any.
+func (u *upstream) deliver(f *stomp.Frame) {
+	u.lock.Lock()
+	sub, ok := u.subs[f.Headers["subscription"]]
+	u.lock.Unlock()
+	if !ok {
+		return
+	}
+	f.Headers["subscription"] = sub.id
+	if ack, ok := f.Headers["ack"]; ok {
+		sub.s.delivered(sub, ack)
+	}
+	select {
+	case sub.s.out <- f:
+	default:
+		sub.s.conn.Close()
+	}
+}
+
+// session is a downstream connection.
+type session struct {
+	conn     io.ReadWriteCloser
+	dec      *stomp.Decoder
+	upstream *upstream
+
+	wlock sync.Mutex
+	enc   *stomp.Encoder
+
+	// out queues messages routed from the upstream connection until
+	// done is closed.
+	out  chan *stomp.Frame
+	done chan struct{}
+
+	// acks are the ack IDs of the messages delivered to the session
+	// and not yet acknowledged.
+	alock sync.Mutex
+	acks  map[string]pendingAck
+	seq   uint64
+
+	// subs maps downstream subscription IDs to upstream IDs.
+	subs map[string]string
+	txs  map[string]*stomp.Tx
+
+	// txAcks are the ack IDs acknowledged within each transaction,
+	// which are only settled once it is committed.
+	txAcks map[string][]string
+}
+
+// pendingAck is a message awaiting acknowledgement by a session.
+type pendingAck struct {
+	sub  string
+	mode stomp.AckMode
+	seq  uint64
+}
+
+// delivered records that the message with ack ID ack has been routed
+// to the session on sub.
+func (s *session) delivered(sub subscriber, ack string) {
+	s.alock.Lock()
+	defer s.alock.Unlock()
+	s.seq++
+	s.acks[ack] = pendingAck{sub: sub.id, mode: sub.mode, seq: s.seq}
+}
+
+// owns reports whether the message with ack ID ack was delivered to
+// the session and has not been acknowledged.
+func (s *session) owns(ack string) bool {
+	s.alock.Lock()
+	defer s.alock.Unlock()
+	_, ok := s.acks[ack]
+	return ok
+}
+
+// settle forgets the message with ack ID ack and, on a client mode
+// subscription, the messages before it that the ack also covers.
+func (s *session) settle(ack string) {
+	s.alock.Lock()
+	defer s.alock.Unlock()
+	p, ok := s.acks[ack]
+	if !ok {
+		return
+	}
+	delete(s.acks, ack)
+	if p.mode != stomp.ClientMode {
+		return
+	}
+	for id, q := range s.acks {
+		if q.sub == p.sub && q.seq < p.seq {
+			delete(s.acks, id)
+		}
+	}
+}
+
+// forget drops the pending messages delivered on the subscription id.
+func (s *session) forget(id string) {
+	s.alock.Lock()
+	defer s.alock.Unlock()
+	for ack, p := range s.acks {
+		if p.sub == id {
+			delete(s.acks, ack)
+		}
+	}
+}
+
+func (s *session) write(f *stomp.Frame) error {
+	s.wlock.Lock()
+	defer s.wlock.Unlock()
+	return s.enc.Encode(f)
+}
+
+// writeQueued writes queued messages until the session ends.
+func (s *session) writeQueued() {
+	for {
+		select {
+		case f := <-s.out:
+			if err := s.write(f); err != nil {
+				s.conn.Close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// fail sends an ERROR frame and closes the connection.
+func (s *session) fail(msg string) {
+	f := stomp.NewFrame("ERROR", strings.NewReader(msg))
+	f.Headers["message"] = msg
+	f.Headers["content-type"] = "text/plain"
+	f.Headers["content-length"] = fmt.Sprint(len(msg))
+	s.write(f)
+	s.conn.Close()
+}
+
+func (s *session) receipt(f *stomp.Frame) error {
+	rid, ok := f.Headers["receipt"]
+	if !ok {
+		return nil
+	}
+	r := stomp.NewFrame("RECEIPT", nil)
+	r.Headers["receipt-id"] = rid
+	return s.write(r)
+}
+
+func (s *session) serve() {
+	defer s.cleanup()
+
+	var f stomp.Frame
+	if err := s.dec.Decode(&f); err != nil {
+		s.conn.Close()
+		return
+	}
+	if f.Command != "CONNECT" && f.Command != "STOMP" {
+		s.fail("expected CONNECT frame")
+		return
+	}
+	if !acceptsVersion(f.Headers["accept-version"]) {
+		s.fail("supported protocol versions are " + stomp.Version)
+		return
+	}
+
+	r := stomp.NewFrame("CONNECTED", nil)
+	r.Headers["version"] = stomp.Version
+	r.Headers["heart-beat"] = "0,0"
+	r.Headers["server"] = "stomp-gateway"
+	if err := s.write(r); err != nil {
+		s.conn.Close()
+		return
+	}
+
+	for {
+		var f stomp.Frame
+		if err := s.dec.Decode(&f); err != nil {
+			s.conn.Close()
+			return
+		}
+		if f.Command == "HEARTBEAT" {
+			continue
+		}
+		if f.Command == "DISCONNECT" {
+			s.receipt(&f)
+			s.conn.Close()
+			return
+		}
+
+		err := s.handle(&f)
+		if err != nil {
+			s.fail(err.Error())
+			return
+		}
+		if err := s.receipt(&f); err != nil {
+			s.conn.Close()
+			return
+		}
+	}
+}
+
+func acceptsVersion(v string) bool {
+	for _, a := range strings.Split(v, ",") {
+		if strings.TrimSpace(a) == stomp.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// handle forwards f upstream. Frames carrying a receipt request are
+// forwarded with an upstream receipt, so the downstream receipt is only
+// sent once the broker has processed the frame. Transactions do not take
+// receipts for SEND, ACK and NACK; those frames are confirmed once sent.
+func (s *session) handle(f *stomp.Frame) error {
+	c := s.upstream.client
+	_, receipt := f.Headers["receipt"]
+	tid, inTx := f.Headers["transaction"]
+
+	var tx *stomp.Tx
+	if inTx && f.Command != "BEGIN" {
+		var ok bool
+		tx, ok = s.txs[tid]
+		if !ok {
+			return fmt.Errorf("unknown transaction %s", tid)
+		}
+	}
+
+	switch f.Command {
+	case "SEND":
+		dest := f.Headers["destination"]
+		hdrs := f.Headers
+		if tx != nil {
+			return tx.Send(dest, &hdrs, f.Headers["content-type"], f.Body)
+		}
+		return c.Send(dest, &hdrs, f.Headers["content-type"], f.Body, receipt)
+	case "SUBSCRIBE":
+		id := f.Headers["id"]
+		if _, ok := s.subs[id]; ok {
+			return fmt.Errorf("duplicate subscription %s", id)
+		}
+		mode := stomp.AckMode(f.Headers["ack"])
+		if mode == "" {
+			mode = stomp.AutoMode
+		}
+		// Route the subscription before it is made, as messages may
+		// arrive before the receipt.
+		s.upstream.lock.Lock()
+		uid := s.upstream.nextID()
+		s.upstream.subs[uid] = subscriber{s: s, id: id, mode: mode}
+		s.upstream.lock.Unlock()
+
+		hdrs := f.Headers
+		err := c.SubscribeWithID(uid, f.Headers["destination"], mode, &hdrs, receipt)
+		if err != nil {
+			s.upstream.lock.Lock()
+			delete(s.upstream.subs, uid)
+			s.upstream.lock.Unlock()
+			return err
+		}
+		s.subs[id] = uid
+		return nil
+	case "UNSUBSCRIBE":
+		id := f.Headers["id"]
+		uid, ok := s.subs[id]
+		if !ok {
+			return fmt.Errorf("unknown subscription %s", id)
+		}
+		delete(s.subs, id)
+		s.upstream.lock.Lock()
+		delete(s.upstream.subs, uid)
+		s.upstream.lock.Unlock()
+		s.forget(id)
+		return c.Unsubscribe(uid, receipt)
+	case "ACK", "NACK":
+		id := f.Headers["id"]
+		if !s.owns(id) {
+			return fmt.Errorf("unknown message %s", id)
+		}
+		var err error
+		switch {
+		case tx != nil && f.Command == "ACK":
+			err = tx.Ack(id)
+		case tx != nil:
+			err = tx.Nack(id)
+		case f.Command == "ACK":
+			err = c.Ack(id, receipt)
+		default:
+			err = c.Nack(id, receipt)
+		}
+		if err != nil {
+			return err
+		}
+		if tx != nil {
+			s.txAcks[tid] = append(s.txAcks[tid], id)
+		} else {
+			s.settle(id)
+		}
+		return nil
+	case "BEGIN":
+		if _, ok := s.txs[tid]; ok || !inTx {
+			return fmt.Errorf("invalid transaction %s", tid)
+		}
+		tx, err := c.Begin(receipt)
+		if err != nil {
+			return err
+		}
+		s.txs[tid] = tx
+		return nil
+	case "COMMIT":
+		if tx == nil {
+			return fmt.Errorf("missing transaction")
+		}
+		acks := s.txAcks[tid]
+		delete(s.txs, tid)
+		delete(s.txAcks, tid)
+		err := tx.Commit(receipt)
+		if err != nil {
+			return err
+		}
+		for _, id := range acks {
+			s.settle(id)
+		}
+		return nil
+	case "ABORT":
+		if tx == nil {
+			return fmt.Errorf("missing transaction")
+		}
+		delete(s.txs, tid)
+		delete(s.txAcks, tid)
+		return tx.Abort(receipt)
+	}
+	return fmt.Errorf("unsupported frame %s", f.Command)
+}
+
+// cleanup releases the session's upstream subscriptions and transactions.
+func (s *session) cleanup() {
+	c := s.upstream.client
+	for id, uid := range s.subs {
+		s.upstream.lock.Lock()
+		delete(s.upstream.subs, uid)
+		s.upstream.lock.Unlock()
+		c.Unsubscribe(uid, false)
+		delete(s.subs, id)
+	}
+	for tid, tx := range s.txs {
+		tx.Abort(false)
+		delete(s.txs, tid)
+	}
+}
@@ -0,0 +1,49 @@
+package stomp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// activity records the time of the last send or delivery on a client.
+type activity struct {
+	last int64
+}
+
+func newActivity() *activity {
+	a := &activity{}
+	a.touch()
+	return a
+}
+
+func (a *activity) touch() {
+	atomic.StoreInt64(&a.last, time.Now().UnixNano())
+}
+
+func (a *activity) since() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&a.last)))
+}
+
+// idle disconnects the client once no sends or deliveries
+// have occurred for the duration d.
+func (c *Client) idle(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.receipts.closed:
+			return
+		case <-t.C:
+			if since := c.activity.since(); since < d {
+				t.Reset(d - since)
+				continue
+			}
+			c.Disconnect()
+			return
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package stomp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
+// Mirror defines a destination whose messages are copied to another
+// destination. Mirroring is best effort; failing to send a copy does
+// not affect the original message.
+type Mirror struct {
+	// Source is the destination whose messages are mirrored.
+	Source string
+
+	// Target is the destination receiving the copies.
+	Target string
+
+	// Received mirrors messages received from Source.
+	Received bool
+
+	// Sent mirrors messages sent to Source.
+	Sent bool
+}
+
+// Provenance headers attached to mirrored messages.
+const (
+	MirrorSourceHeader    = "x-mirror-source"
+	MirrorDirectionHeader = "x-mirror-direction"
+	MirrorMessageIDHeader = "x-mirror-message-id"
+)
+
+// findMirror returns the mirror for messages sent (or received) on dest.
+func findMirror(mirrors []Mirror, dest string, sent bool) (Mirror, bool) {
+	for _, m := range mirrors {
+		if m.Source == dest && ((sent && m.Sent) || (!sent && m.Received)) {
+			return m, true
+		}
+	}
+	return Mirror{}, false
+}
+
+// readBody reads body fully so that it may be sent more than once.
+func readBody(body io.Reader) ([]byte, error) {
+	if body == nil {
+		return nil, nil
+	}
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if rc, ok := body.(io.Closer); ok {
+		err = rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// mirrorHeaders returns a copy of hdrs with provenance headers attached.
+func mirrorHeaders(hdrs map[string]string, dest string, direction string) *map[string]string {
+	m := make(map[string]string, len(hdrs)+3)
+	for k, v := range hdrs {
+		m[k] = v
+	}
+	m[MirrorSourceHeader] = dest
+	m[MirrorDirectionHeader] = direction
+	return &m
+}
+
+// sendMirrored sends a message to dest with send, then sends a copy
+// to the target of m with mirror.
+func sendMirrored(m Mirror, dest string, hdrs *map[string]string, body io.Reader, send func(io.Reader) error, mirror func(*map[string]string, io.Reader) error) error {
+	buf, err := readBody(body)
+	if err != nil {
+		return err
+	}
+
+	err = send(bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+
+	var orig map[string]string
+	if hdrs != nil {
+		orig = *hdrs
+	}
+	mirror(mirrorHeaders(orig, dest, "sent"), bytes.NewReader(buf))
+	return nil
+}
+
+// receivedOnly lists MESSAGE headers that are not carried over to a copy.
+var receivedOnly = []string{"message-id", "subscription", "ack", "destination", "content-length", "content-type"}
+
+// mirrorReceived returns a stage that sends a copy of each message
+// received on a mirrored destination over t.
+func mirrorReceived(t *Transport, mirrors []Mirror) stage {
+	return func(in <-chan *Frame, out chan<- *Frame) {
+		defer close(out)
+
+		for f := range in {
+			dest := f.Headers["destination"]
+			if m, ok := findMirror(mirrors, dest, false); ok {
+				buf, err := readBody(f.Body)
+				if err == nil {
+					f.Body = ioutil.NopCloser(bytes.NewReader(buf))
+
+					hdrs := mirrorHeaders(f.Headers, dest, "received")
+					for _, k := range receivedOnly {
+						delete(*hdrs, k)
+					}
+					(*hdrs)[MirrorMessageIDHeader] = f.Headers["message-id"]
+					t.Send(m.Target, hdrs, f.Headers["content-type"], bytes.NewReader(buf), nil)
+				}
+			}
+			out <- f
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package stomp
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reloader holds TLS material and credentials read from files that may be
+// replaced while the process runs. Connections made after a reload use the
+// new values; existing connections are unaffected.
+//
+// Use Reloader.TLSConfig as TransportConfig.GetTLSConfig and
+// Reloader.Credentials as Config.Credentials.
+type Reloader struct {
+	base         *tls.Config
+	files        TLSFiles
+	loginFile    string
+	passcodeFile string
+
+	lock     sync.RWMutex
+	tls      *tls.Config
+	login    string
+	passcode string
+	mtimes   map[string]time.Time
+}
+
+// NewReloader creates a reloader and loads its files.
+// Each TLS configuration is a clone of base, which may be nil, with
+// RootCAs and Certificates replaced by those loaded from files; a file
+// that is not set leaves the base value in place.
+// The login and passcode files may be empty, in which case the
+// respective credential is not used.
+func NewReloader(base *tls.Config, files TLSFiles, loginFile string, passcodeFile string) (*Reloader, error) {
+	r := &Reloader{
+		base:         base,
+		files:        files,
+		loginFile:    loginFile,
+		passcodeFile: passcodeFile,
+	}
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) paths() []string {
+	var paths []string
+	for _, p := range []string{r.files.CAFile, r.files.CertFile, r.files.KeyFile, r.loginFile, r.passcodeFile} {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+func readSecret(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+// Reload reads all files again.
+// If any file cannot be loaded, the previous values are kept.
+// Reload may be called from a signal handler goroutine to reload on demand.
+func (r *Reloader) Reload() error {
+	mtimes := make(map[string]time.Time)
+	for _, p := range r.paths() {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		mtimes[p] = fi.ModTime()
+	}
+
+	loaded, err := r.files.Config()
+	if err != nil {
+		return err
+	}
+	conf := &tls.Config{}
+	if r.base != nil {
+		conf = r.base.Clone()
+	}
+	if loaded.RootCAs != nil {
+		conf.RootCAs = loaded.RootCAs
+	}
+	if loaded.Certificates != nil {
+		conf.Certificates = loaded.Certificates
+	}
+	login, err := readSecret(r.loginFile)
+	if err != nil {
+		return err
+	}
+	passcode, err := readSecret(r.passcodeFile)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.tls = conf
+	r.login = login
+	r.passcode = passcode
+	r.mtimes = mtimes
+	return nil
+}
+
+func (r *Reloader) changed() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, p := range r.paths() {
+		fi, err := os.Stat(p)
+		if err != nil {
+			// The file may be mid-replacement; check again later.
+			continue
+		}
+		if !fi.ModTime().Equal(r.mtimes[p]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch polls the files every interval and reloads them when any has
+// changed, until stop is closed. Reload errors are passed to errf,
+// which may be nil.
+func (r *Reloader) Watch(interval time.Duration, stop <-chan struct{}, errf func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if !r.changed() {
+				continue
+			}
+			err := r.Reload()
+			if err != nil && errf != nil {
+				errf(err)
+			}
+		}
+	}
+}
+
+// TLSConfig returns a copy of the current TLS configuration.
+func (r *Reloader) TLSConfig() (*tls.Config, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.tls.Clone(), nil
+}
+
+// Credentials returns the current login and passcode.
+func (r *Reloader) Credentials() (string, string, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.login, r.passcode, nil
+}
@@ -0,0 +1,162 @@
+package stomp
+
+import (
+	"sync/atomic"
+)
+
+// ring is a bounded single-producer, single-consumer queue of frames.
+// The producer and consumer only synchronize through atomic indices;
+// channels are used solely to park a side that is waiting.
+type ring struct {
+	buf  []*Frame
+	mask uint64
+
+	head uint64 // next slot to read, owned by the consumer
+	tail uint64 // next slot to write, owned by the producer
+
+	closed   int32
+	notEmpty chan struct{}
+	notFull  chan struct{}
+}
+
+// newRing creates a ring holding at least n frames.
+// The capacity is rounded up to a power of two.
+func newRing(n int) *ring {
+	size := uint64(1)
+	for size < uint64(n) {
+		size <<= 1
+	}
+	return &ring{
+		buf:      make([]*Frame, size),
+		mask:     size - 1,
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Push adds f, blocking while the ring is full.
+// If quit is closed while waiting, f is dropped and Push returns false.
+func (r *ring) Push(f *Frame, quit <-chan struct{}) bool {
+	t := r.tail
+	for t-atomic.LoadUint64(&r.head) == uint64(len(r.buf)) {
+		select {
+		case <-r.notFull:
+		case <-quit:
+			return false
+		}
+	}
+	r.buf[t&r.mask] = f
+	atomic.StoreUint64(&r.tail, t+1)
+	signal(r.notEmpty)
+	return true
+}
+
+// Close marks the end of the stream. Push must not be called after Close.
+func (r *ring) Close() {
+	atomic.StoreInt32(&r.closed, 1)
+	signal(r.notEmpty)
+}
+
+// PopBatch moves up to len(dst) frames into dst, blocking until at
+// least one is available. PopBatch returns zero once the ring has
+// been closed and drained.
+func (r *ring) PopBatch(dst []*Frame) int {
+	h := r.head
+	for {
+		t := atomic.LoadUint64(&r.tail)
+		if t == h && atomic.LoadInt32(&r.closed) != 0 {
+			// Close happens after the final push, so the tail
+			// must be read again before reporting the end.
+			t = atomic.LoadUint64(&r.tail)
+			if t == h {
+				return 0
+			}
+		}
+		if t == h {
+			<-r.notEmpty
+			continue
+		}
+
+		n := t - h
+		if n > uint64(len(dst)) {
+			n = uint64(len(dst))
+		}
+		for i := uint64(0); i < n; i++ {
+			dst[i] = r.buf[(h+i)&r.mask]
+			r.buf[(h+i)&r.mask] = nil
+		}
+		atomic.StoreUint64(&r.head, h+n)
+		signal(r.notFull)
+		return int(n)
+	}
+}
+
+// Receive moves up to len(batch) received MESSAGE frames into batch,
+// blocking until at least one is available, and returns the number moved.
+// Once the connection has ended and all messages have been received,
+// Receive returns zero and the reason the connection ended.
+//
+// With Config.RingBuffer set, Receive is the only way to receive messages
+// and must not be called concurrently; otherwise it reads from MsgCh.
+func (c *Client) Receive(batch []*Frame) (int, error) {
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	if c.ring != nil {
+		n := c.ring.PopBatch(batch)
+		if n == 0 {
+			return 0, c.Err()
+		}
+		return n, nil
+	}
+
+	n := receiveChan(c.MsgCh, batch)
+	if n == 0 {
+		return 0, c.Err()
+	}
+	return n, nil
+}
+
+// receiveChan moves up to len(batch) frames from ch into batch, blocking
+// until at least one is available. receiveChan returns zero once ch is
+// closed and drained.
+func receiveChan(ch <-chan *Frame, batch []*Frame) int {
+	f, ok := <-ch
+	if !ok {
+		return 0
+	}
+	batch[0] = f
+	n := 1
+	for n < len(batch) {
+		select {
+		case f, ok := <-ch:
+			if !ok {
+				return n
+			}
+			batch[n] = f
+			n++
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// pump moves frames from the delivery stages into the ring.
+// After Disconnect, frames that do not fit are dropped so that
+// the stages can drain.
+func (c *Client) pump(in <-chan *Frame) {
+	for f := range in {
+		c.ring.Push(f, c.quit)
+	}
+	c.ring.Close()
+	close(c.MsgCh)
+}
@@ -0,0 +1,64 @@
+package stomp
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// PendingMessage identifies a message delivered on a ClientMode or
+// ClientIndividualMode subscription that had not been acknowledged.
+type PendingMessage struct {
+	Subscription string `json:"subscription"`
+	MessageID    string `json:"message-id"`
+	Ack          string `json:"ack"`
+}
+
+// Session is a serializable snapshot of a client's logical session.
+type Session struct {
+	// Subscriptions are the active subscriptions keyed by ID, including
+	// any headers given when subscribing, such as durable subscription names.
+	Subscriptions map[string]Subscription `json:"subscriptions"`
+
+	// Pending are the unacknowledged messages at the time of the snapshot,
+	// in the order they were delivered.
+	// Ack IDs are only valid on the connection that delivered them; after
+	// a restore, the broker redelivers these messages and Pending may be
+	// used to recognize them by message ID.
+	Pending []PendingMessage `json:"pending"`
+}
+
+// Snapshot returns the current logical session of the client.
+func (c *Client) Snapshot() *Session {
+	return &Session{
+		Subscriptions: c.subs.All(),
+		Pending:       c.subs.Pending(),
+	}
+}
+
+// MarshalSession encodes s as JSON.
+func MarshalSession(s *Session) ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// UnmarshalSession decodes a session encoded with MarshalSession.
+func UnmarshalSession(buf []byte) (*Session, error) {
+	var s Session
+	err := json.Unmarshal(buf, &s)
+	if err != nil {
+		return nil, fmt.Errorf("stomp: unable to decode session: %v", err)
+	}
+	return &s, nil
+}
+
+// Restore recreates the subscriptions of s on the client,
+// keeping their original subscription IDs.
+// A true receipt value will use a receipt for each frame.
+func (c *Client) Restore(s *Session, receipt bool) error {
+	for id, sub := range s.Subscriptions {
+		err := c.subscribeID(id, sub, receipt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
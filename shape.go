@@ -0,0 +1,74 @@
+package stomp
+
+import (
+	"net"
+	"time"
+)
+
+// Shaping defines simulated network conditions for a connection.
+// It is intended for testing behaviour over slow links and against
+// frames split across many reads.
+type Shaping struct {
+	// Latency is the delay added to every read and write.
+	Latency time.Duration
+
+	// Bandwidth limits the throughput of each direction, in bytes per second.
+	// Zero means unlimited.
+	Bandwidth int
+
+	// MaxSegment limits the number of bytes moved by a single read
+	// or write on the underlying connection, fragmenting frames.
+	// Zero means no fragmentation.
+	MaxSegment int
+}
+
+// Wrap returns conn with the shaping applied.
+// A nil Shaping returns conn unchanged.
+func (s *Shaping) Wrap(conn net.Conn) net.Conn {
+	if s == nil {
+		return conn
+	}
+	return &shapedConn{Conn: conn, s: *s}
+}
+
+type shapedConn struct {
+	net.Conn
+	s Shaping
+}
+
+func (c *shapedConn) delay(n int) {
+	d := c.s.Latency
+	if c.s.Bandwidth > 0 {
+		d += time.Duration(n) * time.Second / time.Duration(c.s.Bandwidth)
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func (c *shapedConn) segment(p []byte) []byte {
+	if c.s.MaxSegment > 0 && len(p) > c.s.MaxSegment {
+		return p[:c.s.MaxSegment]
+	}
+	return p
+}
+
+func (c *shapedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(c.segment(p))
+	c.delay(n)
+	return n, err
+}
+
+func (c *shapedConn) Write(p []byte) (int, error) {
+	var done int
+	for done < len(p) {
+		seg := c.segment(p[done:])
+		c.delay(len(seg))
+		n, err := c.Conn.Write(seg)
+		done += n
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
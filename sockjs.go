@@ -0,0 +1,266 @@
+package stomp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrSockJSBinary is returned when sending a frame that is not valid
+// UTF-8 over SockJS, whose messages are text only.
+var ErrSockJSBinary = errors.New("stomp: sockjs cannot carry non UTF-8 frames")
+
+// maxSockJSFrame bounds the size of a single SockJS frame, which may
+// batch several STOMP frames. It leaves room for a frame body of
+// maxBodySize even when every byte is escaped in JSON.
+const maxSockJSFrame = 1 << 30
+
+// DialSockJS opens a SockJS session with the endpoint at url,
+// such as http://localhost:8080/stomp, for use with ConnectStream.
+// The xhr-streaming transport is used, falling back to xhr polling
+// if the endpoint does not support streaming. WebSocket is not
+// negotiated; brokers exposing WebSocket should be dialed directly.
+// SockJS messages are text, so frames with bodies that are not valid
+// UTF-8 cannot be sent and fail with ErrSockJSBinary.
+// A nil client will use http.DefaultClient. The client's Timeout applies
+// to the long-lived xhr-streaming receive request as well, and ends the
+// session when it expires, so clients with a Timeout should not be used.
+func DialSockJS(url string, client *http.Client) (io.ReadWriteCloser, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	server, err := rand.Int(rand.Reader, big.NewInt(1000))
+	if err != nil {
+		return nil, err
+	}
+	session, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	s := &sockJS{
+		client: client,
+		base:   fmt.Sprintf("%s/%03d/%s", strings.TrimRight(url, "/"), server, strings.Replace(session, "-", "", -1)),
+		ctx:    ctx,
+		cancel: cancel,
+		pr:     pr,
+		pw:     pw,
+	}
+
+	resp, err := s.post("/xhr_streaming", nil)
+	if err == nil && resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		s.poll = true
+		resp, err = s.post("/xhr", nil)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("stomp: sockjs session failed with status %s", resp.Status)
+	}
+
+	r := bufio.NewReaderSize(resp.Body, 4096)
+	for {
+		line, err := readSockJSFrame(r)
+		if err != nil {
+			resp.Body.Close()
+			cancel()
+			return nil, err
+		}
+		if strings.HasPrefix(line, "h") {
+			// Heart-beats and the xhr-streaming prelude.
+			continue
+		}
+		if line != "o" {
+			resp.Body.Close()
+			cancel()
+			return nil, fmt.Errorf("stomp: sockjs session not opened")
+		}
+		break
+	}
+
+	go s.run(resp, r)
+
+	return s, nil
+}
+
+// sockJS is a SockJS session carrying a STOMP stream.
+type sockJS struct {
+	client *http.Client
+	base   string
+	poll   bool
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	pr *io.PipeReader
+	pw *io.PipeWriter
+
+	lock    sync.Mutex
+	pending []byte
+}
+
+func (s *sockJS) post(path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", s.base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	return s.client.Do(req.WithContext(s.ctx))
+}
+
+func readSockJSFrame(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		b, err := r.ReadSlice('\n')
+		if len(line)+len(b) > maxSockJSFrame {
+			return "", fmt.Errorf("stomp: sockjs frame exceeds %d bytes", maxSockJSFrame)
+		}
+		line = append(line, b...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(line) > 0 {
+			// Polling responses need not end with a newline.
+			return strings.TrimRight(string(line), "\n"), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\n"), nil
+	}
+}
+
+// run copies received messages to the pipe, reopening the receiving
+// request whenever the server ends it.
+func (s *sockJS) run(resp *http.Response, r *bufio.Reader) {
+	for {
+		err := s.consume(r)
+		resp.Body.Close()
+		if err != nil {
+			s.pw.CloseWithError(err)
+			return
+		}
+
+		path := "/xhr_streaming"
+		if s.poll {
+			path = "/xhr"
+		}
+		resp, err = s.post(path, nil)
+		if err != nil {
+			s.pw.CloseWithError(err)
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			s.pw.CloseWithError(fmt.Errorf("stomp: sockjs receive failed with status %s", resp.Status))
+			return
+		}
+		r = bufio.NewReaderSize(resp.Body, 4096)
+	}
+}
+
+// consume reads frames until the response ends.
+// A close frame from the server is reported as io.EOF.
+func (s *sockJS) consume(r *bufio.Reader) error {
+	for {
+		line, err := readSockJSFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			continue
+		}
+
+		switch line[0] {
+		case 'o', 'h':
+		case 'a':
+			var msgs []string
+			err = json.Unmarshal([]byte(line[1:]), &msgs)
+			if err != nil {
+				return fmt.Errorf("stomp: unable to decode sockjs frame: %v", err)
+			}
+			for _, m := range msgs {
+				_, err = io.WriteString(s.pw, m)
+				if err != nil {
+					return err
+				}
+			}
+		case 'c':
+			return io.EOF
+		default:
+			return fmt.Errorf("stomp: unknown sockjs frame %q", line[:1])
+		}
+	}
+}
+
+// Read reads the concatenated messages received from the server.
+func (s *sockJS) Read(p []byte) (int, error) {
+	return s.pr.Read(p)
+}
+
+// Write buffers p until the transport marks the end of a frame.
+func (s *sockJS) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pending = append(s.pending, p...)
+	return len(p), nil
+}
+
+// flushFrame sends the buffered frame or heart-beat as a single
+// SockJS message.
+func (s *sockJS) flushFrame() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.pending) == 0 {
+		return nil
+	}
+	if !utf8.Valid(s.pending) {
+		s.pending = s.pending[:0]
+		return ErrSockJSBinary
+	}
+	body, err := json.Marshal([]string{string(s.pending)})
+	s.pending = s.pending[:0]
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.post("/xhr_send", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("stomp: sockjs send failed with status %s", resp.Status)
+	}
+	return nil
+}
+
+// Close ends the session.
+func (s *sockJS) Close() error {
+	s.cancel()
+	return s.pr.Close()
+}
@@ -0,0 +1,144 @@
+package stomp
+
+import (
+	"sort"
+	"sync"
+)
+
+// subscriptions tracks a client's subscriptions and the messages
+// delivered on client and client-individual subscriptions that are
+// not yet acknowledged.
+type subscriptions struct {
+	lock    sync.Mutex
+	subs    map[string]Subscription
+	unacked map[string]unacked
+	seq     uint64
+}
+
+// unacked is a pending message and its position in delivery order.
+type unacked struct {
+	PendingMessage
+	seq uint64
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{
+		subs:    make(map[string]Subscription),
+		unacked: make(map[string]unacked),
+	}
+}
+
+func (s *subscriptions) Add(id string, sub Subscription) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subs[id] = sub
+}
+
+// Remove forgets the subscription id and its unacknowledged messages,
+// which can no longer be acknowledged once it is unsubscribed.
+func (s *subscriptions) Remove(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.subs, id)
+	for ack, m := range s.unacked {
+		if m.Subscription == id {
+			delete(s.unacked, ack)
+		}
+	}
+}
+
+// Delivered records f as unacknowledged if it was delivered
+// on a client or client-individual subscription.
+func (s *subscriptions) Delivered(f *Frame) {
+	ack, ok := f.Headers["ack"]
+	if !ok {
+		return
+	}
+	id := f.Headers["subscription"]
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sub, ok := s.subs[id]
+	if !ok || (sub.Mode != ClientMode && sub.Mode != ClientIndividualMode) {
+		return
+	}
+	s.seq++
+	s.unacked[ack] = unacked{
+		PendingMessage: PendingMessage{
+			Subscription: id,
+			MessageID:    f.Headers["message-id"],
+			Ack:          ack,
+		},
+		seq: s.seq,
+	}
+}
+
+// Acked records that the message with ack ID ack has been acknowledged.
+// On a client subscription, the acknowledgement is cumulative and also
+// covers the messages delivered before it on the same subscription.
+func (s *subscriptions) Acked(ack string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	m, ok := s.unacked[ack]
+	if !ok {
+		return
+	}
+	delete(s.unacked, ack)
+	if s.subs[m.Subscription].Mode != ClientMode {
+		return
+	}
+	for id, p := range s.unacked {
+		if p.Subscription == m.Subscription && p.seq < m.seq {
+			delete(s.unacked, id)
+		}
+	}
+}
+
+// ordered returns the unacknowledged messages in delivery order.
+// The caller must hold s.lock.
+func (s *subscriptions) ordered() []unacked {
+	msgs := make([]unacked, 0, len(s.unacked))
+	for _, m := range s.unacked {
+		msgs = append(msgs, m)
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].seq < msgs[j].seq
+	})
+	return msgs
+}
+
+// Unacked returns the ack IDs of the unacknowledged messages
+// in delivery order.
+func (s *subscriptions) Unacked() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	msgs := s.ordered()
+	ids := make([]string, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.Ack
+	}
+	return ids
+}
+
+// Pending returns the unacknowledged messages in delivery order.
+func (s *subscriptions) Pending() []PendingMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	msgs := s.ordered()
+	pending := make([]PendingMessage, len(msgs))
+	for i, m := range msgs {
+		pending[i] = m.PendingMessage
+	}
+	return pending
+}
+
+// All returns a copy of the subscriptions keyed by ID.
+func (s *subscriptions) All() map[string]Subscription {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	subs := make(map[string]Subscription, len(s.subs))
+	for id, sub := range s.subs {
+		subs[id] = sub
+	}
+	return subs
+}
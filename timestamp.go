@@ -0,0 +1,109 @@
+package stomp
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// timestampHeaders are the headers brokers use to stamp messages,
+// in milliseconds since the Unix epoch.
+var timestampHeaders = []string{"timestamp", "JMSTimestamp"}
+
+// Timestamp returns the time the broker stamped the frame with, read from
+// its timestamp or JMSTimestamp header. The result is false if neither
+// header holds a valid timestamp.
+func (f *Frame) Timestamp() (time.Time, bool) {
+	for _, h := range timestampHeaders {
+		v, ok := f.Headers[h]
+		if !ok {
+			continue
+		}
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || ms <= 0 {
+			continue
+		}
+		return time.Unix(0, ms*int64(time.Millisecond)), true
+	}
+	return time.Time{}, false
+}
+
+// defaultSkewWindow is the number of samples a ClockSkew considers by default.
+const defaultSkewWindow = 128
+
+// ClockSkew estimates the offset between the broker clock and the local clock
+// from the timestamps of received messages.
+//
+// The apparent latency of a message is the difference between the time it was
+// received and its timestamp, which includes both the true latency and the
+// clock offset. ClockSkew assumes the fastest recent message had negligible
+// latency, taking its apparent latency as the offset.
+type ClockSkew struct {
+	// Window is the number of recent samples considered.
+	// Zero means 128.
+	Window int
+
+	lock    sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+// Observe records f, received at recv, and returns its apparent latency.
+// The result is false if f carries no timestamp.
+func (c *ClockSkew) Observe(f *Frame, recv time.Time) (time.Duration, bool) {
+	ts, ok := f.Timestamp()
+	if !ok {
+		return 0, false
+	}
+	d := recv.Sub(ts)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	n := c.Window
+	if n <= 0 {
+		n = defaultSkewWindow
+	}
+	if len(c.samples) < n {
+		c.samples = append(c.samples, d)
+	} else {
+		c.samples[c.next%len(c.samples)] = d
+	}
+	c.next++
+
+	return d, true
+}
+
+// Skew returns the estimated amount the local clock is ahead of the broker clock.
+// The result is false if no samples have been observed.
+func (c *ClockSkew) Skew() (time.Duration, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.samples) == 0 {
+		return 0, false
+	}
+	min := c.samples[0]
+	for _, d := range c.samples[1:] {
+		if d < min {
+			min = d
+		}
+	}
+	return min, true
+}
+
+// Latency returns the end-to-end latency of f, received at recv,
+// corrected for the estimated clock skew. Latency does not record f.
+// The result is false if f carries no timestamp or no samples have
+// been observed.
+func (c *ClockSkew) Latency(f *Frame, recv time.Time) (time.Duration, bool) {
+	ts, ok := f.Timestamp()
+	if !ok {
+		return 0, false
+	}
+	skew, ok := c.Skew()
+	if !ok {
+		return 0, false
+	}
+	return recv.Sub(ts) - skew, true
+}
@@ -0,0 +1,47 @@
+package stomp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSFiles locates PEM encoded TLS material on disk.
+type TLSFiles struct {
+	// CAFile is a bundle of certificate authorities used to verify
+	// the server. If CAFile is empty, the system roots are used.
+	CAFile string
+
+	// CertFile and KeyFile are the client certificate and private key.
+	// Both must be set to present a client certificate.
+	CertFile string
+	KeyFile  string
+}
+
+// Config loads the files and returns a TLS configuration using them.
+func (t TLSFiles) Config() (*tls.Config, error) {
+	conf := &tls.Config{}
+
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("stomp: no certificates found in %s", t.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+}
@@ -4,23 +4,39 @@ import (
 	"bytes"
 	"io"
 	"io/ioutil"
-	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Transport represents a STOMP 1.2 compatible connection.
 // A transport object provides STOMP functionality atop an underlying
 // stream.
+// Frames may be sent concurrently; receiving is not safe for concurrent use.
 type Transport struct {
 	enc  *Encoder
 	dec  *Decoder
-	conn net.Conn
+	conn io.ReadWriteCloser
+	lock sync.Mutex
+}
+
+// readDeadliner is implemented by streams supporting read deadlines,
+// such as net.Conn.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// frameFlusher is implemented by streams that buffer writes into
+// messages, such as SockJS, and must be told where each frame ends.
+type frameFlusher interface {
+	flushFrame() error
 }
 
 // NewTransport returns a new transport object that wraps conn.
-func NewTransport(conn net.Conn) *Transport {
+// Receive timeouts are only supported if conn has a SetReadDeadline
+// method, as net.Conn does.
+func NewTransport(conn io.ReadWriteCloser) *Transport {
 	return &Transport{
 		enc:  NewEncoder(conn),
 		dec:  NewDecoder(conn),
@@ -28,6 +44,19 @@ func NewTransport(conn net.Conn) *Transport {
 	}
 }
 
+func (t *Transport) encode(f *Frame) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	err := t.enc.Encode(f)
+	if err != nil {
+		return err
+	}
+	if ff, ok := t.conn.(frameFlusher); ok {
+		return ff.flushFrame()
+	}
+	return nil
+}
+
 // Close closes the underlying stream.
 func (t *Transport) Close() (err error) {
 	return t.conn.Close()
@@ -38,13 +67,13 @@ func (t *Transport) Close() (err error) {
 func (t *Transport) Disconnect(receipt string) error {
 	f := NewFrame("DISCONNECT", nil)
 	f.Headers["receipt"] = receipt
-	return t.enc.Encode(f)
+	return t.encode(f)
 }
 
 // Heartbeat sends a heart-beat frame.
 func (t *Transport) Heartbeat() error {
 	f := NewFrame("HEARTBEAT", nil)
-	return t.enc.Encode(f)
+	return t.encode(f)
 }
 
 // Send sends a message to requested destination dest.
@@ -59,7 +88,7 @@ func (t *Transport) Send(dest string, hdrs *map[string]string, bodyType string,
 	if receipt != nil {
 		f.Headers["receipt"] = *receipt
 	}
-	return t.enc.Encode(f)
+	return t.encode(f)
 }
 
 // Ack sends an ACK frame.
@@ -70,7 +99,7 @@ func (t *Transport) Ack(id string, receipt *string) error {
 	if receipt != nil {
 		f.Headers["receipt"] = *receipt
 	}
-	return t.enc.Encode(f)
+	return t.encode(f)
 }
 
 // Nack sends a NACK frame.
@@ -81,20 +110,36 @@ func (t *Transport) Nack(id string, receipt *string) error {
 	if receipt != nil {
 		f.Headers["receipt"] = *receipt
 	}
-	return t.enc.Encode(f)
+	return t.encode(f)
 }
 
 // Subscribe initiates a subscription to the requested destination dest.
 // A non-nil receipt value will be attached to the frame.
 func (t *Transport) Subscribe(id string, dest string, mode AckMode, receipt *string) error {
+	return t.SubscribeWithHeaders(id, dest, mode, nil, receipt)
+}
+
+// SubscribeWithHeaders behaves just as Subscribe does, with the exception
+// of attaching the additional headers hdrs to the frame.
+// The parameter hdrs may be nil, indicating that it will not be used.
+func (t *Transport) SubscribeWithHeaders(id string, dest string, mode AckMode, hdrs *map[string]string, receipt *string) error {
 	f := NewFrame("SUBSCRIBE", nil)
+	if hdrs != nil {
+		for k, v := range *hdrs {
+			// Keep the original case, as for activemq.subscriptionName.
+			lk := strings.ToLower(k)
+			if _, ok := forbidden[lk]; !ok && lk != "ack" {
+				f.Headers[k] = v
+			}
+		}
+	}
 	f.Headers["destination"] = dest
 	f.Headers["id"] = id
 	f.Headers["ack"] = string(mode)
 	if receipt != nil {
 		f.Headers["receipt"] = *receipt
 	}
-	return t.enc.Encode(f)
+	return t.encode(f)
 }
 
 // Unsubscribe unsubscribes from the subscription with id.
@@ -105,7 +150,7 @@ func (t *Transport) Unsubscribe(id string, receipt *string) error {
 	if receipt != nil {
 		f.Headers["receipt"] = *receipt
 	}
-	return t.enc.Encode(f)
+	return t.encode(f)
 }
 
 // TxBegin sends a BEGIN frame.
@@ -116,7 +161,7 @@ func (t *Transport) TxBegin(tid string, receipt *string) error {
 	if receipt != nil {
 		f.Headers["receipt"] = *receipt
 	}
-	return t.enc.Encode(f)
+	return t.encode(f)
 }
 
 // TxCommit sends a COMMIT frame.
@@ -127,7 +172,7 @@ func (t *Transport) TxCommit(tid string, receipt *string) error {
 	if receipt != nil {
 		f.Headers["receipt"] = *receipt
 	}
-	return t.enc.Encode(f)
+	return t.encode(f)
 }
 
 // TxAbort sends a ABORT frame.
@@ -138,7 +183,7 @@ func (t *Transport) TxAbort(tid string, receipt *string) error {
 	if receipt != nil {
 		f.Headers["receipt"] = *receipt
 	}
-	return t.enc.Encode(f)
+	return t.encode(f)
 }
 
 // TxSend behaves just as Send does, with the exception of being
@@ -149,7 +194,7 @@ func (t *Transport) TxSend(tid string, dest string, hdrs *map[string]string, bod
 		return err
 	}
 	f.Headers["transaction"] = tid
-	return t.enc.Encode(f)
+	return t.encode(f)
 }
 
 // TxAck behaves just as Ack does, with the exception of being
@@ -158,7 +203,7 @@ func (t *Transport) TxAck(tid string, id string) error {
 	f := NewFrame("ACK", nil)
 	f.Headers["id"] = id
 	f.Headers["transaction"] = tid
-	return t.enc.Encode(f)
+	return t.encode(f)
 }
 
 // TxNack behaves just as Nack does, with the exception of being
@@ -167,14 +212,14 @@ func (t *Transport) TxNack(tid string, id string) error {
 	f := NewFrame("NACK", nil)
 	f.Headers["id"] = id
 	f.Headers["transaction"] = tid
-	return t.enc.Encode(f)
+	return t.encode(f)
 }
 
 // Recv returns a frame from the underlying stream.
 // Any errors encountered while reading will be returned.
 func (t *Transport) Recv(timeout time.Duration) (*Frame, error) {
-	if timeout > 0 {
-		t.conn.SetReadDeadline(time.Now().Add(timeout * 2))
+	if rd, ok := t.conn.(readDeadliner); ok && timeout > 0 {
+		rd.SetReadDeadline(time.Now().Add(timeout * 2))
 	}
 	f := &Frame{}
 	err := t.dec.Decode(f)
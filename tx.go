@@ -9,12 +9,28 @@ import (
 // a commit or abort.
 var ErrTxDone = errors.New("stomp: transaction has already been committed or aborted")
 
+// txTransport sends the frames of a transaction.
+type txTransport interface {
+	TxCommit(tid string, receipt *string) error
+	TxAbort(tid string, receipt *string) error
+	TxSend(tid string, dest string, hdrs *map[string]string, bodyType string, body io.Reader) error
+	TxAck(tid string, id string) error
+	TxNack(tid string, id string) error
+}
+
 // Tx represents an ongoing STOMP transaction.
 type Tx struct {
 	tid       string
 	done      bool
 	receipts  *receipts
-	transport *Transport
+	activity  *activity
+	subs      *subscriptions
+	mirrors   []Mirror
+	transport txTransport
+
+	// acks are the ack IDs acknowledged within the transaction.
+	// They stay unacknowledged in subs until the commit succeeds.
+	acks []string
 }
 
 // Commit commits the transaction.
@@ -26,15 +42,25 @@ func (t *Tx) Commit(receipt bool) error {
 		t.done = true
 	}()
 
+	var err error
 	if receipt {
-		return doWithReceipt(t.receipts, func(rid string) error {
+		err = doWithReceipt(t.receipts, func(rid string) error {
 			return t.transport.TxCommit(t.tid, &rid)
 		})
+	} else {
+		err = t.transport.TxCommit(t.tid, nil)
 	}
-	return t.transport.TxCommit(t.tid, nil)
+	if err != nil {
+		return err
+	}
+	for _, id := range t.acks {
+		t.subs.Acked(id)
+	}
+	return nil
 }
 
 // Abort aborts the transaction.
+// Messages acknowledged within the transaction remain unacknowledged.
 // Abort will not return ErrTxDone so it is safe to call
 // after commiting, for instance, when defered.
 func (t *Tx) Abort(receipt bool) error {
@@ -57,19 +83,46 @@ func (t *Tx) Abort(receipt bool) error {
 // The parameters hdrs and body may be nil, indicating that they
 // will not be used for the sent message.
 // Send automatically generates a content-length for the provided body.
+// Copies of mirrored messages are sent within the transaction.
 func (t *Tx) Send(dest string, hdrs *map[string]string, bodyType string, body io.Reader) error {
 	if t.done {
 		return ErrTxDone
 	}
+	t.activity.touch()
+	if m, ok := findMirror(t.mirrors, dest, true); ok {
+		return sendMirrored(m, dest, hdrs, body, func(body io.Reader) error {
+			return t.transport.TxSend(t.tid, dest, hdrs, bodyType, body)
+		}, func(hdrs *map[string]string, body io.Reader) error {
+			return t.transport.TxSend(t.tid, m.Target, hdrs, bodyType, body)
+		})
+	}
 	return t.transport.TxSend(t.tid, dest, hdrs, bodyType, body)
 }
 
+// SendMulti sends a single message to each of the destinations dests
+// using ActiveMQ composite destination syntax, such as queue://a,topic://b.
+// Each destination must be a queue or topic, as /queue/name or queue://name.
+// SendMulti otherwise behaves just as Send does.
+func (t *Tx) SendMulti(dests []string, hdrs *map[string]string, bodyType string, body io.Reader) error {
+	dest, err := compositeDestination(dests)
+	if err != nil {
+		return err
+	}
+	return t.Send(dest, hdrs, bodyType, body)
+}
+
 // Ack sends an ACK frame.
 func (t *Tx) Ack(id string) error {
 	if t.done {
 		return ErrTxDone
 	}
-	return t.transport.TxAck(t.tid, id)
+	t.activity.touch()
+	err := t.transport.TxAck(t.tid, id)
+	if err != nil {
+		return err
+	}
+	t.acks = append(t.acks, id)
+	return nil
 }
 
 // Nack sends a NACK frame.
@@ -77,5 +130,11 @@ func (t *Tx) Nack(id string) error {
 	if t.done {
 		return ErrTxDone
 	}
-	return t.transport.TxNack(t.tid, id)
+	t.activity.touch()
+	err := t.transport.TxNack(t.tid, id)
+	if err != nil {
+		return err
+	}
+	t.acks = append(t.acks, id)
+	return nil
 }